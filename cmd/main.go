@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"expvar"
 	"log"
 	"net/http"
 
@@ -39,8 +40,14 @@ func main() {
 
 	queries := db.New(pool)
 	hub := chat.NewHub(queries)
+	hub.EnableCluster(chat.NewCluster(db.GetRedis(), cfg.NodeID))
+	hub.EnablePresence()
+	if err := hub.EnableRateLimit(cfg.WSRateLimits); err != nil {
+		log.Fatalf("Invalid WS_RATE_LIMITS: %v", err)
+	}
 	go hub.Run()
-	go worker.StartDBWorker(cfg, queries)
+	go worker.StartDBWorker(cfg, pool, queries, hub)
+	go worker.StartFileCleanupWorker(queries)
 
 	userClient := client.NewUserClient(cfg.UserServiceURL)
 	chatService := chat.NewChatService(queries, pool, userClient)
@@ -48,27 +55,62 @@ func main() {
 	lkService := meeting.NewLiveKitService()
 	meetingService := meeting.NewMeetingService(pool, queries, lkService)
 
-	meetingHandler := meeting.NewMeetingHandler(meetingService)
+	meetingHandler := meeting.NewMeetingHandler(meetingService, hub)
 	chatHandler := chat.NewHandler(hub, chatService)
 
 	mux := http.NewServeMux()
 
 	mux.HandleFunc("/ws", chatHandler.ServeWS)
 
-	mux.HandleFunc("/upload", middleware.WithAuth(storage.UploadHandler))
+	mux.HandleFunc("/upload", middleware.WithAuth(storage.NewUploadHandler(queries)))
 
 	mux.HandleFunc("/conversations/private", middleware.WithAuth(chatHandler.HandleGetOrCreatePrivateConv))
 	mux.HandleFunc("/conversations/detail", middleware.WithAuth(chatHandler.HandleGetConversation))
 	mux.HandleFunc("/conversations/unread-count", middleware.WithAuth(chatHandler.HandleGetUnreadCount))
 	mux.HandleFunc("/conversations", middleware.WithAuth(chatHandler.HandleListConversations))
-
+	mux.HandleFunc("/conversations/mute", middleware.WithAuth(chatHandler.HandleConversationPreferences))
+	mux.HandleFunc("/conversations/unmute", middleware.WithAuth(chatHandler.HandleConversationPreferences))
+	mux.HandleFunc("/conversations/archive", middleware.WithAuth(chatHandler.HandleConversationPreferences))
+	mux.HandleFunc("/conversations/unarchive", middleware.WithAuth(chatHandler.HandleConversationPreferences))
+	mux.HandleFunc("/conversations/pin", middleware.WithAuth(chatHandler.HandleConversationPreferences))
+	mux.HandleFunc("/conversations/unpin", middleware.WithAuth(chatHandler.HandleConversationPreferences))
+
+	mux.HandleFunc("/groups", middleware.WithAuth(chatHandler.HandleCreateGroup))
+	mux.HandleFunc("/groups/members/add", middleware.WithAuth(chatHandler.HandleUpdateGroup))
+	mux.HandleFunc("/groups/members/remove", middleware.WithAuth(chatHandler.HandleUpdateGroup))
+	mux.HandleFunc("/groups/info", middleware.WithAuth(chatHandler.HandleUpdateGroup))
+	mux.HandleFunc("/groups/role", middleware.WithAuth(chatHandler.HandleUpdateGroup))
+	mux.HandleFunc("/groups/leave", middleware.WithAuth(chatHandler.HandleUpdateGroup))
+
+	mux.HandleFunc("/messages/edit", middleware.WithAuth(chatHandler.HandleEditMessage))
+	mux.HandleFunc("/messages/edits", middleware.WithAuth(chatHandler.HandleListMessageEdits))
+	mux.HandleFunc("/messages/delete", middleware.WithAuth(chatHandler.HandleDeleteMessage))
+	mux.HandleFunc("/messages/thread", middleware.WithAuth(chatHandler.HandleGetThread))
+	mux.HandleFunc("/messages/seen", middleware.WithAuth(chatHandler.HandleGetSeenBy))
+	mux.HandleFunc("/messages/forward", middleware.WithAuth(chatHandler.HandleForwardMessages))
+	mux.HandleFunc("/messages/search", middleware.WithAuth(chatHandler.HandleSearchMessages))
+	mux.HandleFunc("/messages/pin", middleware.WithAuth(chatHandler.HandlePinMessage))
+	mux.HandleFunc("/messages/unpin", middleware.WithAuth(chatHandler.HandlePinMessage))
+	mux.HandleFunc("/messages/pinned", middleware.WithAuth(chatHandler.HandleListPinnedMessages))
 	mux.HandleFunc("/messages", middleware.WithAuth(chatHandler.HandleGetMessages))
 
+	mux.HandleFunc("/presence", middleware.WithAuth(chatHandler.HandleGetPresence))
+
 	mux.HandleFunc("/meetings/my", middleware.WithAuth(meetingHandler.ListMyMeetings))
 	mux.HandleFunc("/meetings/join", middleware.WithAuth(meetingHandler.JoinMeeting))
 	mux.HandleFunc("/meetings/end", middleware.WithAuth(meetingHandler.EndMeeting))
 	mux.HandleFunc("/meetings", middleware.WithAuth(meetingHandler.CreateMeeting))
 
+	// Runtime and chat counters stay off the public port.
+	if cfg.AdminAddr != "" {
+		adminMux := http.NewServeMux()
+		adminMux.Handle("/debug/vars", expvar.Handler())
+		go func() {
+			log.Println("Admin server started on", cfg.AdminAddr)
+			log.Fatal(http.ListenAndServe(cfg.AdminAddr, adminMux))
+		}()
+	}
+
 	handlerWithCORS := middleware.EnableCORS(mux)
 
 	log.Println("Server started on port", cfg.ServerPort)
@@ -24,12 +24,15 @@ var (
 func InitKafka() {
 	once.Do(func() {
 		cfg := config.Get()
+		// Events with the same key land on the same partition, so consumers
+		// see them in the order they were written.
 		w := &kafka.Writer{
 			Addr:         kafka.TCP(cfg.KafkaBroker),
-			Balancer:     &kafka.LeastBytes{},
+			Balancer:     &kafka.Hash{},
 			RequiredAcks: kafka.RequireOne,
 			Async:        true,
 			WriteTimeout: 10 * time.Second,
+			Completion:   completion,
 		}
 		instance = &KafkaProducer{writer: w}
 		log.Println("Kafka Producer initialized successfully")
@@ -43,17 +46,21 @@ func Get() *KafkaProducer {
 	return instance
 }
 
-func (p *KafkaProducer) PushEvent(ctx context.Context, topic, key string, payload any) error {
+// PushEvent queues an event without waiting for the broker. The returned
+// error only covers events that could not be queued; onError, if not nil,
+// is called on its own goroutine when Kafka rejects the event later.
+func (p *KafkaProducer) PushEvent(ctx context.Context, topic, key string, payload any, onError func(error)) error {
 	value, err := json.Marshal(payload)
 	if err != nil {
 		return err
 	}
 
 	err = p.writer.WriteMessages(ctx, kafka.Message{
-		Topic: topic,
-		Key:   []byte(key),
-		Value: value,
-		Time:  time.Now().UTC(),
+		Topic:      topic,
+		Key:        []byte(key),
+		Value:      value,
+		Time:       time.Now().UTC(),
+		WriterData: onError,
 	})
 
 	if err != nil {
@@ -63,6 +70,19 @@ func (p *KafkaProducer) PushEvent(ctx context.Context, topic, key string, payloa
 	return nil
 }
 
+// completion reports the outcome of an asynchronous write batch.
+func completion(messages []kafka.Message, err error) {
+	if err == nil {
+		return
+	}
+	log.Printf("Kafka Write Error: %v", err)
+	for _, m := range messages {
+		if onError, ok := m.WriterData.(func(error)); ok && onError != nil {
+			go onError(err)
+		}
+	}
+}
+
 func (p *KafkaProducer) Close() {
 	if p.writer != nil {
 		p.writer.Close()
@@ -0,0 +1,26 @@
+package broker
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+)
+
+func TestCompletionReportsFailedWrites(t *testing.T) {
+	writeErr := errors.New("broker unavailable")
+	failed := make(chan error, 2)
+	onError := func(err error) { failed <- err }
+
+	completion([]kafka.Message{{WriterData: onError}, {}}, writeErr)
+	if err := <-failed; err != writeErr {
+		t.Errorf("onError got %v, want %v", err, writeErr)
+	}
+
+	completion([]kafka.Message{{WriterData: onError}}, nil)
+	select {
+	case err := <-failed:
+		t.Errorf("onError called for a successful write: %v", err)
+	default:
+	}
+}
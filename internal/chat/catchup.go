@@ -0,0 +1,219 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"corechain-communication/internal/config"
+	"corechain-communication/internal/db"
+)
+
+const defaultCatchUpMaxMessages = 500
+
+// SyncFrame brackets the catch-up stream sent after a reconnect. A
+// sync_complete carries LastMessageID after a ?since catch-up and Cursors,
+// the new cursor of every conversation caught up, after a ?cursors one.
+type SyncFrame struct {
+	Type           string          `json:"type"`
+	ConversationID int64           `json:"conversation_id,omitempty"`
+	LastMessageID  int64           `json:"last_message_id,omitempty"`
+	Cursors        map[int64]int64 `json:"cursors,omitempty"`
+	Reason         string          `json:"reason,omitempty"`
+}
+
+// catchUpCursor is what a reconnecting client last saw: either one global
+// message ID or the last message ID per conversation.
+type catchUpCursor struct {
+	since         int64
+	conversations map[int64]int64
+}
+
+// parseCatchUpCursor reads ?since=<message_id> or
+// ?cursors=<conversation_id>:<message_id>,... from the /ws request. It
+// returns nil when the client did not ask for a catch-up.
+func parseCatchUpCursor(r *http.Request) (*catchUpCursor, error) {
+	sinceStr := r.URL.Query().Get("since")
+	cursorsStr := r.URL.Query().Get("cursors")
+	if sinceStr == "" && cursorsStr == "" {
+		return nil, nil
+	}
+
+	cursor := &catchUpCursor{}
+	if sinceStr != "" {
+		since, err := strconv.ParseInt(sinceStr, 10, 64)
+		if err != nil || since < 0 {
+			return nil, errors.New("invalid since parameter")
+		}
+		cursor.since = since
+		return cursor, nil
+	}
+
+	cursor.conversations = make(map[int64]int64)
+	for _, pair := range strings.Split(cursorsStr, ",") {
+		convStr, msgStr, ok := strings.Cut(pair, ":")
+		if !ok {
+			return nil, errors.New("invalid cursors parameter")
+		}
+		convID, err1 := strconv.ParseInt(convStr, 10, 64)
+		msgID, err2 := strconv.ParseInt(msgStr, 10, 64)
+		if err1 != nil || err2 != nil || convID <= 0 || msgID < 0 {
+			return nil, errors.New("invalid cursors parameter")
+		}
+		cursor.conversations[convID] = msgID
+	}
+	return cursor, nil
+}
+
+func catchUpMaxMessages() int {
+	if n := config.Get().CatchUpMaxMessages; n > 0 {
+		return n
+	}
+	return defaultCatchUpMaxMessages
+}
+
+// catchUp streams the messages and read positions the client missed while
+// it was disconnected. The client must be in replay mode, so live traffic
+// is held back until the backlog has been written. Messages that arrive
+// live during the replay may be sent twice; clients dedupe on id.
+func (h *Hub) catchUp(c *Client, cursor catchUpCursor) {
+	ctx := context.Background()
+	limit := catchUpMaxMessages()
+
+	done := SyncFrame{Type: "sync_complete"}
+	if cursor.conversations == nil {
+		done.LastMessageID = h.catchUpAll(ctx, c, cursor.since, limit)
+	} else {
+		done.Cursors = h.catchUpConversations(ctx, c, cursor.conversations, limit)
+	}
+
+	c.replayJSON(done)
+
+	if c.finishReplay() {
+		c.trySendJSON(SyncFrame{Type: "resync_required", Reason: "live_overflow"})
+	}
+}
+
+func (h *Hub) catchUpAll(ctx context.Context, c *Client, since int64, limit int) int64 {
+	msgs, err := h.q.ListMessagesSince(ctx, db.ListMessagesSinceParams{
+		UserID:     c.UserID,
+		AfterID:    since,
+		LimitCount: int32(limit + 1),
+	})
+	if err != nil {
+		log.Printf("Catch-up failed for user %s: %v", c.UserID, err)
+		c.replayJSON(SyncFrame{Type: "resync_required", Reason: "catch_up_failed"})
+		return since
+	}
+	if len(msgs) > limit {
+		c.replayJSON(SyncFrame{Type: "resync_required", Reason: "too_far_behind"})
+		return since
+	}
+
+	lastID := since
+	for _, m := range h.messagesFromDB(ctx, msgs) {
+		c.replayJSON(m)
+		lastID = m.ID
+	}
+
+	h.replayReadStates(ctx, c, since, func(convID, readID int64) bool { return true })
+	return lastID
+}
+
+// catchUpConversations replays each conversation past its cursor, in
+// conversation ID order so the shared limit always truncates the same ones.
+// It returns the new cursor of every conversation fully caught up.
+func (h *Hub) catchUpConversations(ctx context.Context, c *Client, cursors map[int64]int64, limit int) map[int64]int64 {
+	synced := make(map[int64]int64, len(cursors))
+	var minCursor int64
+	first := true
+	remaining := limit
+
+	for _, convID := range slices.Sorted(maps.Keys(cursors)) {
+		after := cursors[convID]
+		if first || after < minCursor {
+			minCursor = after
+			first = false
+		}
+
+		memberIDs, err := h.conversationMembers(ctx, convID)
+		if err == nil && !slices.Contains(memberIDs, c.UserID) {
+			memberIDs, err = h.refreshConversationMembers(ctx, convID)
+		}
+		if err != nil || !slices.Contains(memberIDs, c.UserID) {
+			delete(cursors, convID)
+			continue
+		}
+
+		msgs, err := h.q.ListConversationMessagesSince(ctx, db.ListConversationMessagesSinceParams{
+			ConversationID: convID,
+			AfterID:        after,
+			UserID:         c.UserID,
+			LimitCount:     int32(remaining + 1),
+		})
+		if err != nil {
+			log.Printf("Catch-up failed for user %s in Conv %d: %v", c.UserID, convID, err)
+			c.replayJSON(SyncFrame{Type: "resync_required", ConversationID: convID, Reason: "catch_up_failed"})
+			continue
+		}
+		if len(msgs) > remaining {
+			c.replayJSON(SyncFrame{Type: "resync_required", ConversationID: convID, Reason: "too_far_behind"})
+			continue
+		}
+
+		remaining -= len(msgs)
+		synced[convID] = after
+		for _, m := range h.messagesFromDB(ctx, msgs) {
+			c.replayJSON(m)
+			synced[convID] = max(synced[convID], m.ID)
+		}
+	}
+
+	h.replayReadStates(ctx, c, minCursor, func(convID, readID int64) bool {
+		after, ok := cursors[convID]
+		return ok && readID > after
+	})
+	return synced
+}
+
+// replayReadStates sends a read_receipt for every read position that moved
+// past the client's cursor.
+func (h *Hub) replayReadStates(ctx context.Context, c *Client, since int64, keep func(convID, readID int64) bool) {
+	rows, err := h.q.ListReadStatesSince(ctx, db.ListReadStatesSinceParams{
+		UserID:  c.UserID,
+		AfterID: since,
+	})
+	if err != nil {
+		log.Printf("Catch-up of read states failed for user %s: %v", c.UserID, err)
+		return
+	}
+
+	for _, r := range rows {
+		if !keep(r.ConversationID, r.LastReadMessageID.Int64) {
+			continue
+		}
+		c.replayJSON(newReadReceipt(r.ConversationID, r.UserID, r.LastReadMessageID.Int64, r.LastReadAt))
+	}
+}
+
+func (c *Client) replayJSON(v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	c.replayFrame(c.encode(data))
+}
+
+func (c *Client) trySendJSON(v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	c.trySend(c.encode(data))
+}
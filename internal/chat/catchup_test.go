@@ -0,0 +1,98 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"net/http/httptest"
+	"testing"
+
+	"corechain-communication/internal/db"
+)
+
+func TestParseCatchUpCursor(t *testing.T) {
+	tests := []struct {
+		query   string
+		want    *catchUpCursor
+		wantErr bool
+	}{
+		{query: "", want: nil},
+		{query: "token=abc", want: nil},
+		{query: "since=0", want: &catchUpCursor{since: 0}},
+		{query: "since=42", want: &catchUpCursor{since: 42}},
+		{query: "since=42&cursors=1:5", want: &catchUpCursor{since: 42}},
+		{query: "cursors=1:5", want: &catchUpCursor{conversations: map[int64]int64{1: 5}}},
+		{query: "cursors=1:5,2:0,3:17", want: &catchUpCursor{conversations: map[int64]int64{1: 5, 2: 0, 3: 17}}},
+		{query: "since=-1", wantErr: true},
+		{query: "since=abc", wantErr: true},
+		{query: "cursors=1", wantErr: true},
+		{query: "cursors=1:5,", wantErr: true},
+		{query: "cursors=0:5", wantErr: true},
+		{query: "cursors=1:-5", wantErr: true},
+		{query: "cursors=x:5", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			got, err := parseCatchUpCursor(httptest.NewRequest("GET", "/ws?"+tt.query, nil))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("accepted, got %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (got == nil) != (tt.want == nil) {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+			if got != nil && (got.since != tt.want.since || !maps.Equal(got.conversations, tt.want.conversations) ||
+				(got.conversations == nil) != (tt.want.conversations == nil)) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCatchUpConversationsTruncatesInOrder(t *testing.T) {
+	s := newTestService(t)
+	ctx := context.Background()
+	first, users := newTestConversation(t, s, "alice", "bob")
+	alice, bob := users[0], users[1]
+	second, _ := newTestConversation(t, s, "bob")
+	if err := s.queries.AddParticipant(ctx, db.AddParticipantParams{ConversationID: second, UserID: alice}); err != nil {
+		t.Fatal(err)
+	}
+
+	var lastOfFirst int64
+	for _, conv := range []int64{first, second} {
+		for range 3 {
+			msg := newTestMessage(t, s, conv, bob, "hi")
+			if conv == first {
+				lastOfFirst = msg.ID
+			}
+		}
+	}
+
+	h := NewHub(s.queries)
+	// Map iteration order is random; the outcome must not be.
+	for range 5 {
+		c := &Client{ID: "web", UserID: alice, Hub: h, Send: make(chan []byte, 16)}
+		got := h.catchUpConversations(ctx, c, map[int64]int64{first: 0, second: 0}, 4)
+		if want := map[int64]int64{first: lastOfFirst}; !maps.Equal(got, want) {
+			t.Fatalf("cursors = %v, want %v", got, want)
+		}
+
+		var frames []string
+		for len(c.Send) > 0 {
+			var frame SyncFrame
+			json.Unmarshal(<-c.Send, &frame)
+			frames = append(frames, frame.Type)
+		}
+		// Three messages of the first conversation, then the second one
+		// is over the remaining limit.
+		if len(frames) != 4 || frames[3] != "resync_required" {
+			t.Fatalf("frames = %v", frames)
+		}
+	}
+}
@@ -1,7 +1,9 @@
 package chat
 
 import (
+	"encoding/json"
 	"log"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -20,10 +22,162 @@ const (
 )
 
 type Client struct {
-	UserID string
-	Hub    *Hub
-	Conn   *websocket.Conn
-	Send   chan []byte
+	// ID identifies this connection; a user may hold several at once.
+	ID       string
+	UserID   string
+	UserName string
+	Hub      *Hub
+	Conn     *websocket.Conn
+	Send     chan []byte
+
+	// Protocol is the negotiated subprotocol, empty for legacy clients.
+	Protocol string
+
+	// SlowConsumerPolicy decides what happens to frames once Send is full;
+	// empty means SlowConsumerCoalesce.
+	SlowConsumerPolicy string
+
+	// lastPresenceRefresh is only touched by the read goroutine.
+	lastPresenceRefresh time.Time
+
+	mu     sync.Mutex
+	closed bool
+
+	// Held back while Send is full, see overflow. evicted is set once the
+	// server is closing the connection, for being too slow or for flooding.
+	coalesced map[string][]byte
+	resync    bool
+	evicted   bool
+
+	// While replaying missed messages after a reconnect, live frames are
+	// held in pending so that the backlog reaches the client first.
+	replaying       bool
+	pending         [][]byte
+	pendingOverflow bool
+
+	// frames limits the raw frames read; it is only used by ReadPump.
+	frames tokenBucket
+
+	limitMu    sync.Mutex
+	violations tokenBucket
+}
+
+// trySend queues data for the write pump without blocking. When Send is
+// full the slow consumer policy applies. It returns false when the client
+// will neither get the frame nor be told to resync.
+func (c *Client) trySend(data []byte) bool {
+	return c.send(data, nil)
+}
+
+// trySendFrame is trySend for a frame shared by a fan-out, which typing
+// and presence frames may be coalesced for.
+func (c *Client) trySendFrame(f *outboundFrame) bool {
+	return c.send(f.encode(c.Protocol), f.coalesceKey)
+}
+
+func (c *Client) send(data []byte, key func() string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed || c.evicted {
+		return false
+	}
+	if c.replaying {
+		if len(c.pending) >= cap(c.Send) {
+			// Dropped; the client is told to resync once the replay ends.
+			c.pendingOverflow = true
+		} else {
+			c.pending = append(c.pending, data)
+		}
+		return true
+	}
+	select {
+	case c.Send <- data:
+		// A parked frame with the same key is older than this one and
+		// would overwrite it when the backlog is flushed.
+		if len(c.coalesced) > 0 && key != nil {
+			delete(c.coalesced, key())
+		}
+		return true
+	default:
+		return c.overflow(data, key)
+	}
+}
+
+// replayFrame writes a catch-up frame, waiting for the write pump when the
+// buffer is full. Send is never closed while replaying, see close.
+func (c *Client) replayFrame(data []byte) bool {
+	c.mu.Lock()
+	closed := c.closed
+	c.mu.Unlock()
+	if closed {
+		return false
+	}
+
+	select {
+	case c.Send <- data:
+		return true
+	case <-time.After(writeWait):
+		return false
+	}
+}
+
+// finishReplay flushes the live frames held back during the replay and
+// switches the client to direct delivery. It reports whether live frames
+// had to be dropped.
+func (c *Client) finishReplay() bool {
+	for {
+		c.mu.Lock()
+		if c.closed {
+			c.replaying = false
+			c.pending = nil
+			close(c.Send)
+			c.mu.Unlock()
+			return false
+		}
+		batch := c.pending
+		c.pending = nil
+		if len(batch) == 0 {
+			c.replaying = false
+			overflowed := c.pendingOverflow
+			c.pendingOverflow = false
+			c.mu.Unlock()
+			return overflowed
+		}
+		c.mu.Unlock()
+
+		for _, data := range batch {
+			if !c.replayFrame(data) {
+				c.mu.Lock()
+				c.pendingOverflow = true
+				c.mu.Unlock()
+				break
+			}
+		}
+	}
+}
+
+// sendError reports a rejected frame back to this connection only.
+func (c *Client) sendError(code, reason string, msg *Message) {
+	data, err := json.Marshal(newErrorFrame(code, reason, msg))
+	if err != nil {
+		return
+	}
+	c.trySend(c.encode(data))
+}
+
+// close closes Send exactly once so the write pump can shut down.
+func (c *Client) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.closed {
+		c.closed = true
+		// A running replay still writes to Send; finishReplay closes it.
+		if !c.replaying {
+			close(c.Send)
+		}
+	}
 }
 
 func (c *Client) ReadPump() {
@@ -37,6 +191,7 @@ func (c *Client) ReadPump() {
 
 	c.Conn.SetPongHandler(func(string) error {
 		c.Conn.SetReadDeadline(time.Now().UTC().Add(pongWait))
+		c.Hub.refreshPresence(c)
 		return nil
 	})
 
@@ -49,7 +204,11 @@ func (c *Client) ReadPump() {
 			break
 		}
 		log.Println("server received message: ", string(message))
-		c.Hub.broadcast <- message
+		if l := c.Hub.limiter; l != nil && !c.frames.allow(l.connection, time.Now()) {
+			c.rateLimited("frame", RateScopeConnection, c.frames.retryAfter(l.connection), nil)
+			continue
+		}
+		c.Hub.broadcast <- inboundFrame{client: c, data: message}
 		log.Println("client sent message to hub: ", string(message))
 	}
 }
@@ -64,15 +223,20 @@ func (c *Client) WritePump() {
 	for {
 		select {
 		case message, ok := <-c.Send:
-			c.Conn.SetWriteDeadline(time.Now().UTC().Add(writeWait))
 			if !ok {
+				c.Conn.SetWriteDeadline(time.Now().UTC().Add(writeWait))
 				c.Conn.WriteMessage(websocket.CloseMessage, []byte{})
 				return
 			}
 
-			if err := c.Conn.WriteMessage(websocket.TextMessage, message); err != nil {
+			if err := c.write(message); err != nil {
 				return
 			}
+			if len(c.Send) == 0 {
+				if err := c.flushBacklog(); err != nil {
+					return
+				}
+			}
 
 		case <-ticker.C:
 			c.Conn.SetWriteDeadline(time.Now().UTC().Add(writeWait))
@@ -82,3 +246,8 @@ func (c *Client) WritePump() {
 		}
 	}
 }
+
+func (c *Client) write(data []byte) error {
+	c.Conn.SetWriteDeadline(time.Now().UTC().Add(writeWait))
+	return c.Conn.WriteMessage(wireMessageType(c.Protocol), data)
+}
@@ -0,0 +1,144 @@
+package chat
+
+import (
+	"context"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// A node is considered dead when its heartbeat key expires.
+	nodeHeartbeatTTL      = 30 * time.Second
+	nodeHeartbeatInterval = 10 * time.Second
+
+	clusterChannelSize = 1024
+)
+
+// Cluster lets several chat instances serve the same users. Each instance
+// subscribes to the delivery channel of every user connected to it and records
+// itself in a Redis registry, so a frame published for a user reaches all of
+// their devices no matter which instance they are connected to.
+type Cluster struct {
+	rdb    *redis.Client
+	nodeID string
+	pubsub *redis.PubSub
+}
+
+func NewCluster(rdb *redis.Client, nodeID string) *Cluster {
+	if nodeID == "" {
+		host, _ := os.Hostname()
+		nodeID = host + "-" + uuid.New().String()[:8]
+	}
+	return &Cluster{
+		rdb:    rdb,
+		nodeID: nodeID,
+	}
+}
+
+func (c *Cluster) NodeID() string {
+	return c.nodeID
+}
+
+func deliveryChannel(userID string) string {
+	return "deliver:" + userID
+}
+
+func userNodesKey(userID string) string {
+	return "user_nodes:" + userID
+}
+
+func nodeKey(nodeID string) string {
+	return "node:" + nodeID
+}
+
+// join subscribes this node to the user's deliveries and registers it as
+// holding at least one of the user's sessions.
+func (c *Cluster) join(ctx context.Context, userID string) error {
+	if err := c.rdb.SAdd(ctx, userNodesKey(userID), c.nodeID).Err(); err != nil {
+		return err
+	}
+	return c.pubsub.Subscribe(ctx, deliveryChannel(userID))
+}
+
+// leave is called once the last local session of the user is gone.
+func (c *Cluster) leave(ctx context.Context, userID string) error {
+	if err := c.rdb.SRem(ctx, userNodesKey(userID), c.nodeID).Err(); err != nil {
+		return err
+	}
+	return c.pubsub.Unsubscribe(ctx, deliveryChannel(userID))
+}
+
+// publish returns the number of nodes that received the frame.
+func (c *Cluster) publish(ctx context.Context, userID string, data []byte) (int64, error) {
+	return c.rdb.Publish(ctx, deliveryChannel(userID), data).Result()
+}
+
+// UserNodes returns the live nodes currently holding a session of the user.
+// Entries left behind by nodes whose heartbeat expired are pruned.
+func (c *Cluster) UserNodes(ctx context.Context, userID string) ([]string, error) {
+	nodeIDs, err := c.rdb.SMembers(ctx, userNodesKey(userID)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	alive := make([]string, 0, len(nodeIDs))
+	for _, id := range nodeIDs {
+		n, err := c.rdb.Exists(ctx, nodeKey(id)).Result()
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			c.rdb.SRem(ctx, userNodesKey(userID), id)
+			continue
+		}
+		alive = append(alive, id)
+	}
+	return alive, nil
+}
+
+func (c *Cluster) heartbeat() {
+	ticker := time.NewTicker(nodeHeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := c.rdb.Set(context.Background(), nodeKey(c.nodeID), time.Now().UTC().Unix(), nodeHeartbeatTTL).Err(); err != nil {
+			log.Printf("Cluster heartbeat failed for node %s: %v", c.nodeID, err)
+		}
+		<-ticker.C
+	}
+}
+
+// EnableCluster switches the hub to cross-instance delivery. It must be
+// called before Run.
+func (h *Hub) EnableCluster(c *Cluster) {
+	// Subscribe to the node's own channel up front so the subscription
+	// connection exists before the first user joins.
+	c.pubsub = c.rdb.Subscribe(context.Background(), nodeKey(c.nodeID))
+	h.cluster = c
+
+	go c.heartbeat()
+	go h.receiveFromCluster()
+
+	log.Printf("Chat cluster enabled, node %s", c.nodeID)
+}
+
+func (h *Hub) receiveFromCluster() {
+	// A fan-out publishes the same payload once per user, back to back, so
+	// reusing the previous frame keeps encoding once per protocol.
+	var last *outboundFrame
+	for m := range h.cluster.pubsub.Channel(redis.WithChannelSize(clusterChannelSize)) {
+		userID, ok := strings.CutPrefix(m.Channel, deliveryChannel(""))
+		if !ok {
+			continue
+		}
+		if last == nil || string(last.data) != m.Payload {
+			last = newOutboundFrame([]byte(m.Payload))
+		}
+		h.deliverLocal(userID, last)
+	}
+}
@@ -0,0 +1,75 @@
+package chat
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// newTestRedis connects to the Redis at TEST_REDIS_ADDR (default
+// localhost:6379) and skips the test when none is running.
+func newTestRedis(t *testing.T) *redis.Client {
+	t.Helper()
+	addr := os.Getenv("TEST_REDIS_ADDR")
+	if addr == "" {
+		addr = "localhost:6379"
+	}
+	rdb := redis.NewClient(&redis.Options{Addr: addr})
+	if err := rdb.Ping(context.Background()).Err(); err != nil {
+		t.Skipf("redis not available at %s: %v", addr, err)
+	}
+	t.Cleanup(func() { rdb.Close() })
+	return rdb
+}
+
+func TestClusterDeliversAcrossHubs(t *testing.T) {
+	rdb := newTestRedis(t)
+	ctx := context.Background()
+
+	hubA := NewHub(nil)
+	hubA.EnableCluster(NewCluster(rdb, "test-node-a"))
+	go hubA.Run()
+
+	hubB := NewHub(nil)
+	hubB.EnableCluster(NewCluster(rdb, "test-node-b"))
+	go hubB.Run()
+
+	recipient := newTestClient(hubB, "cluster-u1", "mobile")
+	hubB.register <- recipient
+
+	waitFor(t, func() bool {
+		nodes, err := hubA.cluster.UserNodes(ctx, "cluster-u1")
+		return err == nil && len(nodes) == 1 && nodes[0] == "test-node-b"
+	})
+	// The registry entry is written before the subscription is confirmed.
+	waitFor(t, func() bool {
+		n, _ := rdb.PubSubNumSub(ctx, deliveryChannel("cluster-u1")).Result()
+		return n[deliveryChannel("cluster-u1")] == 1
+	})
+
+	if !hubA.deliver(ctx, "cluster-u1", []byte("from node a")) {
+		t.Fatal("expected user to be reachable through the cluster")
+	}
+
+	select {
+	case got := <-recipient.Send:
+		if string(got) != "from node a" {
+			t.Errorf("got %q", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("message did not reach the session on node b")
+	}
+
+	if hubA.deliver(ctx, "cluster-nobody", []byte("x")) {
+		t.Error("delivery to a user without sessions should report offline")
+	}
+
+	hubB.unregister <- recipient
+	waitFor(t, func() bool {
+		nodes, _ := hubA.cluster.UserNodes(ctx, "cluster-u1")
+		return len(nodes) == 0
+	})
+}
@@ -0,0 +1,51 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+
+	"corechain-communication/internal/db"
+)
+
+// MessageDeletedEvent tells clients to drop or tombstone a message. With
+// scope "me" it only goes to the other sessions of the user who deleted it.
+type MessageDeletedEvent struct {
+	Type           string `json:"type"`
+	ID             int64  `json:"id"`
+	ConversationID int64  `json:"conversation_id"`
+	Scope          string `json:"scope"`
+	DeletedBy      string `json:"deleted_by"`
+}
+
+// BroadcastDelete fans out a message_deleted event and returns it. Deletions
+// never trigger push notifications.
+func (h *Hub) BroadcastDelete(ctx context.Context, m db.Message, userID, scope string) MessageDeletedEvent {
+	event := MessageDeletedEvent{
+		Type:           "message_deleted",
+		ID:             m.ID,
+		ConversationID: m.ConversationID,
+		Scope:          scope,
+		DeletedBy:      userID,
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		return event
+	}
+
+	if scope == DeleteScopeMe {
+		h.deliver(ctx, userID, data)
+		return event
+	}
+
+	memberIDs, err := h.conversationMembers(ctx, m.ConversationID)
+	if err != nil {
+		log.Printf("Failed to load participants for Conv %d: %v", m.ConversationID, err)
+		return event
+	}
+	frame := newOutboundFrame(data)
+	for _, memberID := range memberIDs {
+		h.deliverFrame(ctx, memberID, frame)
+	}
+	return event
+}
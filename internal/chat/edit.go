@@ -0,0 +1,47 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"corechain-communication/internal/db"
+)
+
+// MessageEditedEvent is broadcast to conversation members after a message's
+// content changed.
+type MessageEditedEvent struct {
+	Type           string    `json:"type"`
+	ID             int64     `json:"id"`
+	ConversationID int64     `json:"conversation_id"`
+	SenderID       string    `json:"sender_id"`
+	Content        string    `json:"content"`
+	EditedAt       time.Time `json:"edited_at"`
+}
+
+// BroadcastEdit sends the new content of an edited message to the members
+// who are connected. Edits never trigger push notifications.
+func (h *Hub) BroadcastEdit(ctx context.Context, m db.Message) {
+	data, err := json.Marshal(MessageEditedEvent{
+		Type:           "message_edited",
+		ID:             m.ID,
+		ConversationID: m.ConversationID,
+		SenderID:       m.SenderID,
+		Content:        m.Content.String,
+		EditedAt:       m.EditedAt.Time,
+	})
+	if err != nil {
+		return
+	}
+
+	memberIDs, err := h.conversationMembers(ctx, m.ConversationID)
+	if err != nil {
+		log.Printf("Failed to load participants for Conv %d: %v", m.ConversationID, err)
+		return
+	}
+	frame := newOutboundFrame(data)
+	for _, memberID := range memberIDs {
+		h.deliverFrame(ctx, memberID, frame)
+	}
+}
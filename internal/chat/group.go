@@ -0,0 +1,537 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"corechain-communication/internal/client"
+	"corechain-communication/internal/db"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const (
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+
+	maxGroupMembers = 256
+	// maxUserLookups bounds the concurrent requests checkUsersExist makes
+	// to the user service.
+	maxUserLookups = 8
+)
+
+var (
+	ErrNotGroup       = errors.New("conversation is not a group")
+	ErrNotAdmin       = errors.New("only group admins can do this")
+	ErrInvalidGroup   = errors.New("a group needs a name and at least one other member")
+	ErrGroupTooLarge  = fmt.Errorf("a group can have at most %d members", maxGroupMembers)
+	ErrInvalidRole    = errors.New("role must be \"admin\" or \"member\"")
+	ErrUserNotInGroup = errors.New("user is not a member of this group")
+	ErrLastAdmin      = errors.New("a group must keep at least one admin")
+	ErrRemoveSelf     = errors.New("use leave to remove yourself from a group")
+	ErrUnknownUser    = errors.New("unknown user")
+)
+
+// ConversationUpdatedEvent is sent to the members of a group, and to the
+// users it concerns, whenever its membership or settings change.
+type ConversationUpdatedEvent struct {
+	Type           string   `json:"type"`
+	ConversationID int64    `json:"conversation_id"`
+	Action         string   `json:"action"`
+	ActorID        string   `json:"actor_id"`
+	UserIDs        []string `json:"user_ids,omitempty"`
+	Name           string   `json:"name,omitempty"`
+	Avatar         string   `json:"avatar,omitempty"`
+	Role           string   `json:"role,omitempty"`
+	NewAdminID     string   `json:"new_admin_id,omitempty"`
+
+	// NameChanged and AvatarChanged tell which fields an info_updated
+	// event actually modified.
+	NameChanged   bool `json:"-"`
+	AvatarChanged bool `json:"-"`
+}
+
+func newConversationUpdate(conversationID int64, action, actorID string) ConversationUpdatedEvent {
+	return ConversationUpdatedEvent{
+		Type:           "conversation_updated",
+		ConversationID: conversationID,
+		Action:         action,
+		ActorID:        actorID,
+	}
+}
+
+// BroadcastConversationUpdate delivers the event to the current members and
+// to the users it names, so removed members learn about their removal.
+func (h *Hub) BroadcastConversationUpdate(ctx context.Context, event ConversationUpdatedEvent) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+
+	memberIDs, err := h.conversationMembers(ctx, event.ConversationID)
+	if err != nil {
+		log.Printf("Failed to load participants for Conv %d: %v", event.ConversationID, err)
+	}
+	for _, id := range event.UserIDs {
+		if !slices.Contains(memberIDs, id) {
+			memberIDs = append(memberIDs, id)
+		}
+	}
+	frame := newOutboundFrame(data)
+	for _, memberID := range memberIDs {
+		h.deliverFrame(ctx, memberID, frame)
+	}
+}
+
+// groupParticipants loads a group's participants and the caller's role in
+// it. It fails when the conversation is not a group the caller belongs to.
+// Changes that act on the result read it inside their transaction after
+// LockConversation, so two admins cannot each pass a check the other's
+// change invalidates.
+func groupParticipants(ctx context.Context, q *db.Queries, conversationID int64, userID string) ([]db.ListParticipantsByConversationRow, string, error) {
+	conv, err := q.GetConversationByID(ctx, conversationID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, "", ErrNotMember
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	if !conv.IsGroup.Bool {
+		return nil, "", ErrNotGroup
+	}
+
+	participants, err := q.ListParticipantsByConversation(ctx, conversationID)
+	if err != nil {
+		return nil, "", err
+	}
+	role, ok := participantRole(participants, userID)
+	if !ok {
+		return nil, "", ErrNotMember
+	}
+	return participants, role, nil
+}
+
+func participantRole(participants []db.ListParticipantsByConversationRow, userID string) (string, bool) {
+	for _, p := range participants {
+		if p.UserID == userID {
+			return p.Role.String, true
+		}
+	}
+	return "", false
+}
+
+func countAdmins(participants []db.ListParticipantsByConversationRow) int {
+	n := 0
+	for _, p := range participants {
+		if p.Role.String == RoleAdmin {
+			n++
+		}
+	}
+	return n
+}
+
+// cacheParticipants refreshes the Redis member set the hub checks every
+// frame against.
+func (s *ChatService) cacheParticipants(ctx context.Context, conversationID int64) {
+	key := strconv.FormatInt(conversationID, 10)
+
+	participants, err := s.queries.ListParticipantsByConversation(ctx, conversationID)
+	if err != nil {
+		log.Printf("Failed to reload participants of Conv %d: %v", conversationID, err)
+		db.DeleteCachedParticipants(ctx, key)
+		return
+	}
+
+	memberIDs := make([]string, len(participants))
+	for i, p := range participants {
+		memberIDs[i] = p.UserID
+	}
+	if len(memberIDs) == 0 {
+		db.DeleteCachedParticipants(ctx, key)
+		return
+	}
+	if err := db.CacheParticipants(ctx, key, memberIDs); err != nil {
+		log.Printf("Failed to cache participants of Conv %d: %v", conversationID, err)
+	}
+}
+
+// newMembers returns the IDs that are not participants yet.
+func newMembers(participants []db.ListParticipantsByConversationRow, memberIDs []string) []string {
+	var out []string
+	for _, id := range memberIDs {
+		if _, ok := participantRole(participants, id); !ok {
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
+// uniqueMembers drops blanks, duplicates and the caller from a member list.
+func uniqueMembers(userID string, memberIDs []string) []string {
+	out := make([]string, 0, len(memberIDs))
+	for _, id := range memberIDs {
+		id = strings.TrimSpace(id)
+		if id != "" && id != userID && !slices.Contains(out, id) {
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
+// checkUsersExist asks the user service about every ID before it is
+// written to participants, so that mistyped IDs never join a group.
+func (s *ChatService) checkUsersExist(ctx context.Context, ids []string) error {
+	var (
+		mu        sync.Mutex
+		wg        sync.WaitGroup
+		unknown   []string
+		lookupErr error
+	)
+	sem := make(chan struct{}, maxUserLookups)
+	for _, id := range ids {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			_, err := s.userClient.GetSingleUser(ctx, id)
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case errors.Is(err, client.ErrUserNotFound):
+				unknown = append(unknown, id)
+			case err != nil:
+				lookupErr = err
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(unknown) > 0 {
+		slices.Sort(unknown)
+		return fmt.Errorf("%w: %s", ErrUnknownUser, strings.Join(unknown, ", "))
+	}
+	return lookupErr
+}
+
+func (s *ChatService) CreateGroup(ctx context.Context, userID, name, avatar string, memberIDs []string) (int64, ConversationUpdatedEvent, error) {
+	name = strings.TrimSpace(name)
+	memberIDs = uniqueMembers(userID, memberIDs)
+	if name == "" || len(memberIDs) == 0 {
+		return 0, ConversationUpdatedEvent{}, ErrInvalidGroup
+	}
+	if len(memberIDs)+1 > maxGroupMembers {
+		return 0, ConversationUpdatedEvent{}, ErrGroupTooLarge
+	}
+	if err := s.checkUsersExist(ctx, memberIDs); err != nil {
+		return 0, ConversationUpdatedEvent{}, err
+	}
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return 0, ConversationUpdatedEvent{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	qtx := s.queries.WithTx(tx)
+
+	conv, err := qtx.CreateConversation(ctx, db.CreateConversationParams{
+		Name:    pgtype.Text{String: name, Valid: true},
+		Avatar:  pgtype.Text{String: avatar, Valid: avatar != ""},
+		IsGroup: pgtype.Bool{Bool: true, Valid: true},
+	})
+	if err != nil {
+		return 0, ConversationUpdatedEvent{}, err
+	}
+
+	err = qtx.AddParticipant(ctx, db.AddParticipantParams{
+		ConversationID: conv.ID,
+		UserID:         userID,
+		Role:           pgtype.Text{String: RoleAdmin, Valid: true},
+	})
+	if err != nil {
+		return 0, ConversationUpdatedEvent{}, err
+	}
+	for _, id := range memberIDs {
+		err = qtx.AddParticipant(ctx, db.AddParticipantParams{
+			ConversationID: conv.ID,
+			UserID:         id,
+			Role:           pgtype.Text{String: RoleMember, Valid: true},
+		})
+		if err != nil {
+			return 0, ConversationUpdatedEvent{}, err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, ConversationUpdatedEvent{}, err
+	}
+	s.cacheParticipants(ctx, conv.ID)
+
+	event := newConversationUpdate(conv.ID, "created", userID)
+	event.UserIDs = append([]string{userID}, memberIDs...)
+	event.Name = name
+	event.Avatar = avatar
+	return conv.ID, event, nil
+}
+
+// AddGroupMembers asks the user service about the new members before the
+// group is locked, then repeats the checks under the lock.
+func (s *ChatService) AddGroupMembers(ctx context.Context, userID string, conversationID int64, memberIDs []string) (ConversationUpdatedEvent, error) {
+	memberIDs = uniqueMembers(userID, memberIDs)
+	participants, role, err := groupParticipants(ctx, s.queries, conversationID, userID)
+	if err != nil {
+		return ConversationUpdatedEvent{}, err
+	}
+	if role != RoleAdmin {
+		return ConversationUpdatedEvent{}, ErrNotAdmin
+	}
+	added := newMembers(participants, memberIDs)
+	if len(participants)+len(added) > maxGroupMembers {
+		return ConversationUpdatedEvent{}, ErrGroupTooLarge
+	}
+	if err := s.checkUsersExist(ctx, added); err != nil {
+		return ConversationUpdatedEvent{}, err
+	}
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return ConversationUpdatedEvent{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	qtx := s.queries.WithTx(tx)
+	if err := qtx.LockConversation(ctx, conversationID); err != nil {
+		return ConversationUpdatedEvent{}, err
+	}
+	participants, role, err = groupParticipants(ctx, qtx, conversationID, userID)
+	if err != nil {
+		return ConversationUpdatedEvent{}, err
+	}
+	if role != RoleAdmin {
+		return ConversationUpdatedEvent{}, ErrNotAdmin
+	}
+	// Anyone not checked above was a participant a moment ago, so is known.
+	added = newMembers(participants, memberIDs)
+	if len(participants)+len(added) > maxGroupMembers {
+		return ConversationUpdatedEvent{}, ErrGroupTooLarge
+	}
+	for _, id := range added {
+		err = qtx.AddParticipant(ctx, db.AddParticipantParams{
+			ConversationID: conversationID,
+			UserID:         id,
+			Role:           pgtype.Text{String: RoleMember, Valid: true},
+		})
+		if err != nil {
+			return ConversationUpdatedEvent{}, err
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return ConversationUpdatedEvent{}, err
+	}
+	s.cacheParticipants(ctx, conversationID)
+
+	event := newConversationUpdate(conversationID, "members_added", userID)
+	event.UserIDs = added
+	return event, nil
+}
+
+func (s *ChatService) RemoveGroupMember(ctx context.Context, userID string, conversationID int64, memberID string) (ConversationUpdatedEvent, error) {
+	if memberID == userID {
+		return ConversationUpdatedEvent{}, ErrRemoveSelf
+	}
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return ConversationUpdatedEvent{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	qtx := s.queries.WithTx(tx)
+	if err := qtx.LockConversation(ctx, conversationID); err != nil {
+		return ConversationUpdatedEvent{}, err
+	}
+	participants, role, err := groupParticipants(ctx, qtx, conversationID, userID)
+	if err != nil {
+		return ConversationUpdatedEvent{}, err
+	}
+	if role != RoleAdmin {
+		return ConversationUpdatedEvent{}, ErrNotAdmin
+	}
+	if _, ok := participantRole(participants, memberID); !ok {
+		return ConversationUpdatedEvent{}, ErrUserNotInGroup
+	}
+
+	err = qtx.RemoveParticipant(ctx, db.RemoveParticipantParams{
+		ConversationID: conversationID,
+		UserID:         memberID,
+	})
+	if err != nil {
+		return ConversationUpdatedEvent{}, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return ConversationUpdatedEvent{}, err
+	}
+	s.cacheParticipants(ctx, conversationID)
+
+	event := newConversationUpdate(conversationID, "member_removed", userID)
+	event.UserIDs = []string{memberID}
+	return event, nil
+}
+
+// UpdateGroupInfo changes the name and/or avatar; nil leaves a field as is.
+func (s *ChatService) UpdateGroupInfo(ctx context.Context, userID string, conversationID int64, name, avatar *string) (ConversationUpdatedEvent, error) {
+	_, role, err := groupParticipants(ctx, s.queries, conversationID, userID)
+	if err != nil {
+		return ConversationUpdatedEvent{}, err
+	}
+	if role != RoleAdmin {
+		return ConversationUpdatedEvent{}, ErrNotAdmin
+	}
+
+	conv, err := s.queries.GetConversationByID(ctx, conversationID)
+	if err != nil {
+		return ConversationUpdatedEvent{}, err
+	}
+	newName, newAvatar := conv.Name.String, conv.Avatar.String
+	if name != nil {
+		newName = strings.TrimSpace(*name)
+	}
+	if avatar != nil {
+		newAvatar = *avatar
+	}
+	if newName == "" {
+		return ConversationUpdatedEvent{}, ErrInvalidGroup
+	}
+
+	err = s.queries.UpdateConversationInfo(ctx, db.UpdateConversationInfoParams{
+		ID:     conversationID,
+		Name:   pgtype.Text{String: newName, Valid: true},
+		Avatar: pgtype.Text{String: newAvatar, Valid: newAvatar != ""},
+	})
+	if err != nil {
+		return ConversationUpdatedEvent{}, err
+	}
+
+	event := newConversationUpdate(conversationID, "info_updated", userID)
+	event.Name = newName
+	event.Avatar = newAvatar
+	event.NameChanged = newName != conv.Name.String
+	event.AvatarChanged = newAvatar != conv.Avatar.String
+	return event, nil
+}
+
+func (s *ChatService) SetGroupRole(ctx context.Context, userID string, conversationID int64, memberID, newRole string) (ConversationUpdatedEvent, error) {
+	if newRole != RoleAdmin && newRole != RoleMember {
+		return ConversationUpdatedEvent{}, ErrInvalidRole
+	}
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return ConversationUpdatedEvent{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	qtx := s.queries.WithTx(tx)
+	if err := qtx.LockConversation(ctx, conversationID); err != nil {
+		return ConversationUpdatedEvent{}, err
+	}
+	participants, role, err := groupParticipants(ctx, qtx, conversationID, userID)
+	if err != nil {
+		return ConversationUpdatedEvent{}, err
+	}
+	if role != RoleAdmin {
+		return ConversationUpdatedEvent{}, ErrNotAdmin
+	}
+	current, ok := participantRole(participants, memberID)
+	if !ok {
+		return ConversationUpdatedEvent{}, ErrUserNotInGroup
+	}
+	if current == RoleAdmin && newRole == RoleMember && countAdmins(participants) == 1 {
+		return ConversationUpdatedEvent{}, ErrLastAdmin
+	}
+
+	err = qtx.UpdateParticipantRole(ctx, db.UpdateParticipantRoleParams{
+		ConversationID: conversationID,
+		UserID:         memberID,
+		Role:           pgtype.Text{String: newRole, Valid: true},
+	})
+	if err != nil {
+		return ConversationUpdatedEvent{}, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return ConversationUpdatedEvent{}, err
+	}
+
+	event := newConversationUpdate(conversationID, "role_changed", userID)
+	event.UserIDs = []string{memberID}
+	event.Role = newRole
+	return event, nil
+}
+
+// LeaveGroup removes the caller. When the last admin leaves, the longest
+// standing remaining member is promoted so the group stays manageable.
+func (s *ChatService) LeaveGroup(ctx context.Context, userID string, conversationID int64) (ConversationUpdatedEvent, error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return ConversationUpdatedEvent{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	qtx := s.queries.WithTx(tx)
+	if err := qtx.LockConversation(ctx, conversationID); err != nil {
+		return ConversationUpdatedEvent{}, err
+	}
+	participants, role, err := groupParticipants(ctx, qtx, conversationID, userID)
+	if err != nil {
+		return ConversationUpdatedEvent{}, err
+	}
+
+	var successor string
+	if role == RoleAdmin && countAdmins(participants) == 1 {
+		var oldest *db.ListParticipantsByConversationRow
+		for i, p := range participants {
+			if p.UserID != userID && (oldest == nil || p.JoinedAt.Time.Before(oldest.JoinedAt.Time)) {
+				oldest = &participants[i]
+			}
+		}
+		if oldest != nil {
+			successor = oldest.UserID
+		}
+	}
+
+	err = qtx.RemoveParticipant(ctx, db.RemoveParticipantParams{
+		ConversationID: conversationID,
+		UserID:         userID,
+	})
+	if err != nil {
+		return ConversationUpdatedEvent{}, err
+	}
+	if successor != "" {
+		err = qtx.UpdateParticipantRole(ctx, db.UpdateParticipantRoleParams{
+			ConversationID: conversationID,
+			UserID:         successor,
+			Role:           pgtype.Text{String: RoleAdmin, Valid: true},
+		})
+		if err != nil {
+			return ConversationUpdatedEvent{}, err
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return ConversationUpdatedEvent{}, err
+	}
+	s.cacheParticipants(ctx, conversationID)
+
+	event := newConversationUpdate(conversationID, "member_left", userID)
+	event.UserIDs = []string{userID}
+	event.NewAdminID = successor
+	return event, nil
+}
@@ -0,0 +1,103 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"corechain-communication/internal/client"
+)
+
+func TestCheckUsersExist(t *testing.T) {
+	users := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch id := strings.TrimPrefix(r.URL.Path, "/users/public/"); id {
+		case "alice", "bob":
+			w.Write([]byte(`{"statusCode":200,"data":{"_id":"` + id + `"}}`))
+		case "down":
+			w.WriteHeader(http.StatusBadGateway)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer users.Close()
+	s := &ChatService{userClient: client.NewUserClient(users.URL)}
+
+	tests := []struct {
+		name    string
+		ids     []string
+		wantErr error
+		wantMsg string
+	}{
+		{name: "all known", ids: []string{"alice", "bob"}},
+		{name: "none", ids: nil},
+		{name: "unknown", ids: []string{"alice", "zed", "carol"}, wantErr: ErrUnknownUser, wantMsg: "unknown user: carol, zed"},
+		{name: "service down", ids: []string{"alice", "down"}, wantMsg: "user service error: 502"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := s.checkUsersExist(context.Background(), tt.ids)
+			if tt.wantMsg == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.wantMsg {
+				t.Fatalf("error = %v, want %q", err, tt.wantMsg)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("error %v is not %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestConcurrentDemotionsKeepAnAdmin(t *testing.T) {
+	s := newTestService(t)
+	ctx := context.Background()
+	convID, users := newTestConversation(t, s, "alice", "bob")
+	alice, bob := users[0], users[1]
+	if _, err := s.SetGroupRole(ctx, alice, convID, bob, RoleAdmin); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each admin demotes the other; without the lock both checks would see
+	// two admins and the group would end up with none.
+	errs := make(chan error, 2)
+	var wg sync.WaitGroup
+	for _, pair := range [][2]string{{alice, bob}, {bob, alice}} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := s.SetGroupRole(ctx, pair[0], convID, pair[1], RoleMember)
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	var failed int
+	for err := range errs {
+		switch {
+		case err == nil:
+		case errors.Is(err, ErrLastAdmin), errors.Is(err, ErrNotAdmin):
+			failed++
+		default:
+			t.Fatal(err)
+		}
+	}
+	if failed != 1 {
+		t.Errorf("%d demotions failed, want 1", failed)
+	}
+	participants, err := s.queries.ListParticipantsByConversation(ctx, convID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := countAdmins(participants); n != 1 {
+		t.Errorf("%d admins left, want 1", n)
+	}
+}
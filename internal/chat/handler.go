@@ -1,19 +1,30 @@
 package chat
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
 
 	"corechain-communication/internal/config"
+	"corechain-communication/internal/db"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 )
 
+const (
+	maxPresenceBatch = 200
+	maxSearchLimit   = 50
+)
+
 type Handler struct {
 	hub     *Hub
 	service *ChatService
@@ -34,6 +45,7 @@ var upgrader = websocket.Upgrader{
 	ReadBufferSize:  4096,
 	WriteBufferSize: 4096,
 	CheckOrigin:     func(r *http.Request) bool { return true },
+	Subprotocols:    subprotocols,
 }
 
 func (h *Handler) ServeWS(w http.ResponseWriter, r *http.Request) {
@@ -52,6 +64,26 @@ func (h *Handler) ServeWS(w http.ResponseWriter, r *http.Request) {
 	}
 
 	userID := fmt.Sprintf("%v", claims["_id"])
+	userName, _ := claims["name"].(string)
+
+	cursor, err := parseCatchUpCursor(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Clients that offer no subprotocol speak the legacy frames until it is
+	// switched off; offering only unknown ones is always an error.
+	offered := websocket.Subprotocols(r)
+	supported := slices.ContainsFunc(offered, func(p string) bool { return slices.Contains(subprotocols, p) })
+	if len(offered) > 0 && !supported {
+		http.Error(w, "Unsupported WebSocket subprotocol", http.StatusBadRequest)
+		return
+	}
+	if len(offered) == 0 && config.Get().WSDisableLegacyProtocol {
+		http.Error(w, "WebSocket subprotocol required: "+strings.Join(subprotocols, ", "), http.StatusBadRequest)
+		return
+	}
 
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
@@ -60,13 +92,26 @@ func (h *Handler) ServeWS(w http.ResponseWriter, r *http.Request) {
 	}
 
 	client := &Client{
-		UserID: userID,
-		Hub:    h.hub,
-		Conn:   conn,
-		Send:   make(chan []byte, 256),
+		ID:       uuid.New().String(),
+		UserID:   userID,
+		UserName: userName,
+		Hub:      h.hub,
+		Conn:     conn,
+		Send:     make(chan []byte, sendQueueSize()),
+		Protocol: conn.Subprotocol(),
+
+		SlowConsumerPolicy: slowConsumerPolicy(),
+	}
+	if client.Protocol == "" {
+		log.Printf("User %s connected with the legacy protocol", userID)
 	}
+	// Hold live traffic until the missed messages have been replayed.
+	client.replaying = cursor != nil
 
 	h.hub.register <- client
+	if cursor != nil {
+		go h.hub.catchUp(client, *cursor)
+	}
 
 	go client.WritePump()
 	go client.ReadPump()
@@ -110,7 +155,124 @@ func (h *Handler) HandleGetOrCreatePrivateConv(w http.ResponseWriter, r *http.Re
 	jsonResponse(w, convDetail)
 }
 
-// GET /conversations
+// POST /groups
+func (h *Handler) HandleCreateGroup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.Context().Value("user_id").(string)
+
+	var req struct {
+		Name      string   `json:"name"`
+		Avatar    string   `json:"avatar"`
+		MemberIDs []string `json:"member_ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid body", http.StatusBadRequest)
+		return
+	}
+
+	convID, event, err := h.service.CreateGroup(r.Context(), userID, req.Name, req.Avatar, req.MemberIDs)
+	if err != nil {
+		writeGroupError(w, err)
+		return
+	}
+	h.postGroupUpdate(r, event)
+
+	convDetail, err := h.service.GetConversation(r.Context(), convID)
+	if err != nil {
+		log.Printf("Error getting conv: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	jsonResponse(w, convDetail)
+}
+
+// POST /groups/members/add, /groups/members/remove, /groups/info, /groups/role, /groups/leave
+func (h *Handler) HandleUpdateGroup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.Context().Value("user_id").(string)
+
+	var req struct {
+		ConversationID int64    `json:"conversation_id"`
+		UserID         string   `json:"user_id"`
+		UserIDs        []string `json:"user_ids"`
+		Name           *string  `json:"name"`
+		Avatar         *string  `json:"avatar"`
+		Role           string   `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid body", http.StatusBadRequest)
+		return
+	}
+	if req.ConversationID == 0 {
+		http.Error(w, "Missing conversation_id", http.StatusBadRequest)
+		return
+	}
+
+	var (
+		event ConversationUpdatedEvent
+		err   error
+	)
+	switch r.URL.Path {
+	case "/groups/members/add":
+		event, err = h.service.AddGroupMembers(r.Context(), userID, req.ConversationID, req.UserIDs)
+	case "/groups/members/remove":
+		event, err = h.service.RemoveGroupMember(r.Context(), userID, req.ConversationID, req.UserID)
+	case "/groups/info":
+		event, err = h.service.UpdateGroupInfo(r.Context(), userID, req.ConversationID, req.Name, req.Avatar)
+	case "/groups/role":
+		event, err = h.service.SetGroupRole(r.Context(), userID, req.ConversationID, req.UserID, req.Role)
+	case "/groups/leave":
+		event, err = h.service.LeaveGroup(r.Context(), userID, req.ConversationID)
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		writeGroupError(w, err)
+		return
+	}
+
+	h.postGroupUpdate(r, event)
+	jsonResponse(w, event)
+}
+
+// postGroupUpdate notifies the members of a group change and records it in
+// the timeline.
+func (h *Handler) postGroupUpdate(r *http.Request, event ConversationUpdatedEvent) {
+	h.hub.BroadcastConversationUpdate(r.Context(), event)
+
+	userName, _ := r.Context().Value("user_name").(string)
+	if payload, ok := h.service.SystemPayloadFor(r.Context(), userName, event); ok {
+		h.hub.PostSystemMessage(r.Context(), event.ConversationID, payload)
+	}
+}
+
+func writeGroupError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrNotMember), errors.Is(err, ErrNotAdmin):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, ErrUserNotInGroup):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrNotGroup), errors.Is(err, ErrInvalidGroup), errors.Is(err, ErrGroupTooLarge),
+		errors.Is(err, ErrInvalidRole), errors.Is(err, ErrRemoveSelf), errors.Is(err, ErrUnknownUser):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, ErrLastAdmin):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		log.Printf("Error updating group: %v", err)
+		http.Error(w, "Failed to update group", http.StatusInternalServerError)
+	}
+}
+
+// GET /conversations?folder=inbox|archived
 func (h *Handler) HandleListConversations(w http.ResponseWriter, r *http.Request) {
 	log.Println("handle list conversation")
 	userID := r.Context().Value("user_id").(string)
@@ -119,7 +281,16 @@ func (h *Handler) HandleListConversations(w http.ResponseWriter, r *http.Request
 	limit := parseQueryInt(r, "limit", 20)
 	offset := parseQueryInt(r, "offset", 0)
 
-	convs, err := h.service.ListConversations(r.Context(), userID, int32(limit), int32(offset))
+	folder := r.URL.Query().Get("folder")
+	if folder == "" {
+		folder = FolderInbox
+	}
+	if folder != FolderInbox && folder != FolderArchived {
+		http.Error(w, "folder must be \"inbox\" or \"archived\"", http.StatusBadRequest)
+		return
+	}
+
+	convs, err := h.service.ListConversations(r.Context(), userID, folder, int32(limit), int32(offset))
 	log.Println("conversations: ", convs)
 	if err != nil {
 		log.Printf("error: %v\n", err)
@@ -130,6 +301,69 @@ func (h *Handler) HandleListConversations(w http.ResponseWriter, r *http.Request
 	jsonResponse(w, convs)
 }
 
+// POST /conversations/mute, /conversations/unmute, /conversations/archive,
+// /conversations/unarchive, /conversations/pin, /conversations/unpin
+func (h *Handler) HandleConversationPreferences(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.Context().Value("user_id").(string)
+
+	var req struct {
+		ConversationID int64      `json:"conversation_id"`
+		MutedUntil     *time.Time `json:"muted_until"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid body", http.StatusBadRequest)
+		return
+	}
+	if req.ConversationID == 0 {
+		http.Error(w, "Missing conversation_id", http.StatusBadRequest)
+		return
+	}
+
+	var (
+		prefs ConversationPreferences
+		err   error
+	)
+	switch r.URL.Path {
+	case "/conversations/mute":
+		prefs, err = h.service.MuteConversation(r.Context(), userID, req.ConversationID, req.MutedUntil)
+	case "/conversations/unmute":
+		prefs, err = h.service.UnmuteConversation(r.Context(), userID, req.ConversationID)
+	case "/conversations/archive":
+		prefs, err = h.service.ArchiveConversation(r.Context(), userID, req.ConversationID, true)
+	case "/conversations/unarchive":
+		prefs, err = h.service.ArchiveConversation(r.Context(), userID, req.ConversationID, false)
+	case "/conversations/pin":
+		prefs, err = h.service.PinConversation(r.Context(), userID, req.ConversationID, true)
+	case "/conversations/unpin":
+		prefs, err = h.service.PinConversation(r.Context(), userID, req.ConversationID, false)
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrNotMember):
+			http.Error(w, err.Error(), http.StatusForbidden)
+		case errors.Is(err, ErrInvalidMuteTime):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, ErrPinnedConversationsLimit):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			log.Printf("Error updating preferences of Conv %d: %v", req.ConversationID, err)
+			http.Error(w, "Failed to update conversation", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	h.hub.BroadcastPreferences(r.Context(), userID, prefs)
+	jsonResponse(w, prefs)
+}
+
 // GET /conversations/messages?conversation_id=123
 func (h *Handler) HandleGetMessages(w http.ResponseWriter, r *http.Request) {
 	convIDStr := r.URL.Query().Get("conversation_id")
@@ -140,7 +374,9 @@ func (h *Handler) HandleGetMessages(w http.ResponseWriter, r *http.Request) {
 
 	limit := parseQueryInt(r, "limit", 20)
 
-	msgs, err := h.service.GetMessages(r.Context(), convID, int32(limit), beforeID)
+	userID := r.Context().Value("user_id").(string)
+
+	msgs, err := h.service.GetMessages(r.Context(), userID, convID, int32(limit), beforeID)
 	if err != nil {
 		http.Error(w, "Failed to fetch messages", http.StatusInternalServerError)
 		return
@@ -149,6 +385,367 @@ func (h *Handler) HandleGetMessages(w http.ResponseWriter, r *http.Request) {
 	jsonResponse(w, msgs)
 }
 
+// POST /messages/edit
+func (h *Handler) HandleEditMessage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.Context().Value("user_id").(string)
+
+	var req struct {
+		ConversationID int64  `json:"conversation_id"`
+		MessageID      int64  `json:"message_id"`
+		Content        string `json:"content"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid body", http.StatusBadRequest)
+		return
+	}
+
+	msg, err := h.service.EditMessage(r.Context(), userID, req.ConversationID, req.MessageID, req.Content)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrEmptyContent):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, ErrNotMember):
+			http.Error(w, "Forbidden", http.StatusForbidden)
+		case errors.Is(err, ErrMessageNotFound):
+			http.Error(w, "Message not found or not yours", http.StatusNotFound)
+		default:
+			log.Printf("Error editing message %d: %v", req.MessageID, err)
+			http.Error(w, "Failed to edit message", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	h.hub.BroadcastEdit(r.Context(), msg)
+	jsonResponse(w, newMessageFromDB(msg))
+}
+
+// POST /messages/delete
+func (h *Handler) HandleDeleteMessage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.Context().Value("user_id").(string)
+
+	var req struct {
+		ConversationID int64  `json:"conversation_id"`
+		MessageID      int64  `json:"message_id"`
+		Scope          string `json:"scope"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid body", http.StatusBadRequest)
+		return
+	}
+
+	msg, err := h.service.DeleteMessage(r.Context(), userID, req.ConversationID, req.MessageID, req.Scope)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrInvalidScope):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, ErrNotMember), errors.Is(err, ErrRecallNotAllowed):
+			http.Error(w, err.Error(), http.StatusForbidden)
+		case errors.Is(err, ErrMessageNotFound):
+			http.Error(w, "Message not found", http.StatusNotFound)
+		default:
+			log.Printf("Error deleting message %d: %v", req.MessageID, err)
+			http.Error(w, "Failed to delete message", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	event := h.hub.BroadcastDelete(r.Context(), msg, userID, req.Scope)
+	jsonResponse(w, event)
+}
+
+// GET /messages/edits?conversation_id=123&message_id=456
+func (h *Handler) HandleListMessageEdits(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(string)
+
+	convID, _ := strconv.ParseInt(r.URL.Query().Get("conversation_id"), 10, 64)
+	msgID, _ := strconv.ParseInt(r.URL.Query().Get("message_id"), 10, 64)
+	if convID == 0 || msgID == 0 {
+		http.Error(w, "Missing conversation_id or message_id parameter", http.StatusBadRequest)
+		return
+	}
+
+	edits, err := h.service.ListMessageEdits(r.Context(), userID, convID, msgID)
+	if err != nil {
+		if errors.Is(err, ErrNotMember) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		log.Printf("Error fetching edits of message %d: %v", msgID, err)
+		http.Error(w, "Failed to fetch edit history", http.StatusInternalServerError)
+		return
+	}
+
+	jsonResponse(w, edits)
+}
+
+// GET /messages/search?q=hello&conversation_id=1&sender_id=u&type=text&from=RFC3339&to=RFC3339&cursor=0&limit=20
+func (h *Handler) HandleSearchMessages(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(string)
+	query := r.URL.Query()
+
+	filter := SearchFilter{
+		Query:    strings.TrimSpace(query.Get("q")),
+		SenderID: query.Get("sender_id"),
+		Type:     query.Get("type"),
+		Limit:    int32(parseQueryInt(r, "limit", 20)),
+	}
+	if filter.Query == "" {
+		http.Error(w, "Missing q parameter", http.StatusBadRequest)
+		return
+	}
+	if filter.Limit <= 0 || filter.Limit > maxSearchLimit {
+		filter.Limit = maxSearchLimit
+	}
+	filter.ConversationID, _ = strconv.ParseInt(query.Get("conversation_id"), 10, 64)
+	filter.BeforeID, _ = strconv.ParseInt(query.Get("cursor"), 10, 64)
+
+	var err error
+	if filter.From, err = parseQueryTime(r, "from"); err != nil {
+		http.Error(w, "Invalid from parameter, expected RFC3339", http.StatusBadRequest)
+		return
+	}
+	if filter.To, err = parseQueryTime(r, "to"); err != nil {
+		http.Error(w, "Invalid to parameter, expected RFC3339", http.StatusBadRequest)
+		return
+	}
+
+	result, err := h.service.SearchMessages(r.Context(), userID, filter)
+	if err != nil {
+		log.Printf("Error searching messages for user %s: %v", userID, err)
+		http.Error(w, "Failed to search messages", http.StatusInternalServerError)
+		return
+	}
+
+	jsonResponse(w, result)
+}
+
+// POST /messages/forward
+func (h *Handler) HandleForwardMessages(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.Context().Value("user_id").(string)
+	userName, _ := r.Context().Value("user_name").(string)
+
+	var req struct {
+		IdempotencyKey  string  `json:"idempotency_key"`
+		MessageIDs      []int64 `json:"message_ids"`
+		ConversationIDs []int64 `json:"conversation_ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid body", http.StatusBadRequest)
+		return
+	}
+	if len(req.MessageIDs) == 0 || len(req.ConversationIDs) == 0 {
+		http.Error(w, "message_ids and conversation_ids are required", http.StatusBadRequest)
+		return
+	}
+	if req.IdempotencyKey == "" {
+		http.Error(w, "idempotency_key is required", http.StatusBadRequest)
+		return
+	}
+
+	msgs, err := h.service.PrepareForward(r.Context(), userID, userName, req.IdempotencyKey, req.MessageIDs, req.ConversationIDs)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrTooManyForwards):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, ErrNotMember):
+			http.Error(w, "Forbidden", http.StatusForbidden)
+		case errors.Is(err, ErrMessageNotFound):
+			http.Error(w, "Message not found", http.StatusNotFound)
+		default:
+			log.Printf("Error preparing forward for user %s: %v", userID, err)
+			http.Error(w, "Failed to forward messages", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// The copies are acked, nacked and broadcast like any message sent over
+	// the WebSocket; the client matches acks on client_msg_id.
+	type queued struct {
+		ClientMsgID    string `json:"client_msg_id"`
+		ConversationID int64  `json:"conversation_id"`
+		ForwardedFrom  int64  `json:"forwarded_from"`
+	}
+	result := make([]queued, 0, len(msgs))
+	for _, msg := range msgs {
+		failed := func(err error) {
+			log.Printf("Failed to push forwarded message for Conv %d: %v", msg.ConversationID, err)
+			h.hub.Nack(context.Background(), msg, "message could not be queued, please retry")
+		}
+		if err := h.hub.Enqueue(r.Context(), msg, failed); err != nil {
+			log.Printf("Failed to queue forwarded message for Conv %d: %v", msg.ConversationID, err)
+			http.Error(w, "Failed to forward messages", http.StatusInternalServerError)
+			return
+		}
+		result = append(result, queued{
+			ClientMsgID:    msg.ClientMsgID,
+			ConversationID: msg.ConversationID,
+			ForwardedFrom:  msg.ForwardedFrom,
+		})
+	}
+
+	jsonResponse(w, result)
+}
+
+// POST /messages/pin and POST /messages/unpin
+func (h *Handler) HandlePinMessage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.Context().Value("user_id").(string)
+
+	var req struct {
+		ConversationID int64 `json:"conversation_id"`
+		MessageID      int64 `json:"message_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid body", http.StatusBadRequest)
+		return
+	}
+
+	unpin := strings.HasSuffix(r.URL.Path, "/unpin")
+
+	var (
+		system  *db.Message
+		changed bool
+		err     error
+	)
+	if unpin {
+		changed, err = h.service.UnpinMessage(r.Context(), userID, req.ConversationID, req.MessageID)
+	} else {
+		userName, _ := r.Context().Value("user_name").(string)
+		system, err = h.service.PinMessage(r.Context(), userID, userName, req.ConversationID, req.MessageID)
+		changed = system != nil
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrNotMember), errors.Is(err, ErrPinNotAllowed):
+			http.Error(w, err.Error(), http.StatusForbidden)
+		case errors.Is(err, ErrMessageNotFound):
+			http.Error(w, "Message not found", http.StatusNotFound)
+		case errors.Is(err, ErrPinLimitReached):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			log.Printf("Error pinning message %d: %v", req.MessageID, err)
+			http.Error(w, "Failed to update pin", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	eventType := "message_pinned"
+	if unpin {
+		eventType = "message_unpinned"
+	}
+	if changed {
+		h.hub.BroadcastPin(r.Context(), eventType, userID, req.ConversationID, req.MessageID, system)
+	}
+
+	jsonResponse(w, PinEvent{
+		Type:           eventType,
+		ConversationID: req.ConversationID,
+		MessageID:      req.MessageID,
+		UserID:         userID,
+	})
+}
+
+// GET /messages/pinned?conversation_id=123
+func (h *Handler) HandleListPinnedMessages(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(string)
+
+	convID, _ := strconv.ParseInt(r.URL.Query().Get("conversation_id"), 10, 64)
+	if convID == 0 {
+		http.Error(w, "Missing conversation_id parameter", http.StatusBadRequest)
+		return
+	}
+
+	pinned, err := h.service.ListPinnedMessages(r.Context(), userID, convID)
+	if err != nil {
+		if errors.Is(err, ErrNotMember) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		log.Printf("Error fetching pinned messages of Conv %d: %v", convID, err)
+		http.Error(w, "Failed to fetch pinned messages", http.StatusInternalServerError)
+		return
+	}
+
+	jsonResponse(w, pinned)
+}
+
+// GET /messages/thread?conversation_id=123&message_id=456&after_id=0&limit=50
+func (h *Handler) HandleGetThread(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(string)
+
+	convID, _ := strconv.ParseInt(r.URL.Query().Get("conversation_id"), 10, 64)
+	msgID, _ := strconv.ParseInt(r.URL.Query().Get("message_id"), 10, 64)
+	if convID == 0 || msgID == 0 {
+		http.Error(w, "Missing conversation_id or message_id parameter", http.StatusBadRequest)
+		return
+	}
+	afterID, _ := strconv.ParseInt(r.URL.Query().Get("after_id"), 10, 64)
+	limit := parseQueryInt(r, "limit", 50)
+
+	thread, err := h.service.GetThread(r.Context(), userID, convID, msgID, afterID, int32(limit))
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrNotMember):
+			http.Error(w, "Forbidden", http.StatusForbidden)
+		case errors.Is(err, ErrMessageNotFound):
+			http.Error(w, "Message not found", http.StatusNotFound)
+		default:
+			log.Printf("Error fetching thread of message %d: %v", msgID, err)
+			http.Error(w, "Failed to fetch thread", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	jsonResponse(w, thread)
+}
+
+// GET /messages/seen?conversation_id=123&message_id=456
+func (h *Handler) HandleGetSeenBy(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(string)
+
+	convID, _ := strconv.ParseInt(r.URL.Query().Get("conversation_id"), 10, 64)
+	msgID, _ := strconv.ParseInt(r.URL.Query().Get("message_id"), 10, 64)
+	if convID == 0 || msgID == 0 {
+		http.Error(w, "Missing conversation_id or message_id parameter", http.StatusBadRequest)
+		return
+	}
+
+	readers, err := h.service.GetSeenBy(r.Context(), userID, convID, msgID)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrNotMember):
+			http.Error(w, "Forbidden", http.StatusForbidden)
+		case errors.Is(err, ErrMessageNotFound):
+			http.Error(w, "Message not found", http.StatusNotFound)
+		default:
+			log.Printf("Error fetching readers of message %d: %v", msgID, err)
+			http.Error(w, "Failed to fetch readers", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	jsonResponse(w, readers)
+}
+
 // GET /conversations/detail?id=123
 func (h *Handler) HandleGetConversation(w http.ResponseWriter, r *http.Request) {
 	idStr := r.URL.Query().Get("id")
@@ -187,6 +784,36 @@ func (h *Handler) HandleGetUnreadCount(w http.ResponseWriter, r *http.Request) {
 	jsonResponse(w, map[string]int64{"total_unread_count": count})
 }
 
+// GET /presence?user_ids=a,b,c
+// Only users sharing a conversation with the caller are returned.
+func (h *Handler) HandleGetPresence(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id").(string)
+
+	var userIDs []string
+	for _, id := range strings.Split(r.URL.Query().Get("user_ids"), ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			userIDs = append(userIDs, id)
+		}
+	}
+	if len(userIDs) == 0 {
+		http.Error(w, "Missing user_ids parameter", http.StatusBadRequest)
+		return
+	}
+	if len(userIDs) > maxPresenceBatch {
+		http.Error(w, fmt.Sprintf("At most %d user_ids per request", maxPresenceBatch), http.StatusBadRequest)
+		return
+	}
+
+	presence, err := h.service.GetPresence(r.Context(), userID, userIDs)
+	if err != nil {
+		log.Printf("Error fetching presence: %v", err)
+		http.Error(w, "Failed to fetch presence", http.StatusInternalServerError)
+		return
+	}
+
+	jsonResponse(w, presence)
+}
+
 // =======================
 // Helpers
 // =======================
@@ -210,6 +837,19 @@ func parseQueryInt(r *http.Request, key string, defaultVal int) int {
 	return val
 }
 
+// parseQueryTime returns nil when the parameter is absent.
+func parseQueryTime(r *http.Request, key string) (*time.Time, error) {
+	valStr := r.URL.Query().Get(key)
+	if valStr == "" {
+		return nil, nil
+	}
+	val, err := time.Parse(time.RFC3339, valStr)
+	if err != nil {
+		return nil, err
+	}
+	return &val, nil
+}
+
 func validateToken(tokenString string) (jwt.MapClaims, error) {
 	jwtSecret := config.Get().JwtSecret
 	token, err := jwt.Parse(tokenString, func(t *jwt.Token) (any, error) {
@@ -8,6 +8,7 @@ import (
 	"corechain-communication/internal/storage"
 	"encoding/json"
 	"log"
+	"slices"
 	"strconv"
 	"sync"
 	"time"
@@ -22,6 +23,21 @@ type Message struct {
 	SenderName     string `json:"sender_name,omitempty"`
 	Content        string `json:"content"`
 
+	ReplyToID int64          `json:"reply_to_id,omitempty"`
+	ReplyTo   *QuotedMessage `json:"reply_to,omitempty"`
+	Mentions  []Mention      `json:"mentions,omitempty"`
+
+	// ForwardedFrom is the original message of a forwarded copy. It is
+	// only ever set by the forward endpoint.
+	ForwardedFrom int64 `json:"forwarded_from,omitempty"`
+
+	// Emoji is set on add_reaction and remove_reaction, with ID naming
+	// the message reacted to.
+	Emoji string `json:"emoji,omitempty"`
+
+	// System is the structured event behind a system message.
+	System *SystemPayload `json:"system,omitempty"`
+
 	FileName string `json:"file_name,omitempty"`
 	FileID   string `json:"file_id,omitempty"`
 	FilePath string `json:"file_path,omitempty"`
@@ -29,27 +45,156 @@ type Message struct {
 	FileType string `json:"file_type,omitempty"`
 	FileSize int64  `json:"file_size,omitempty"`
 
-	CreatedAt time.Time `json:"created_at"`
+	CreatedAt time.Time  `json:"created_at"`
+	EditedAt  *time.Time `json:"edited_at,omitempty"`
+	IsDeleted bool       `json:"is_deleted,omitempty"`
+
+	LastReadMessageID      int64 `json:"last_read_message_id,omitempty"`
+	LastDeliveredMessageID int64 `json:"last_delivered_message_id,omitempty"`
 
-	LastReadMessageID int64 `json:"last_read_message_id,omitempty"`
+	// RequestID is the envelope id of the frame, echoed on the ack, nack
+	// or error frames answering it.
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// newMessageFromDB converts a stored message into the shape sent over the
+// WebSocket, signing the file URL when there is one.
+func newMessageFromDB(m db.Message) Message {
+	msg := Message{
+		ID:             m.ID,
+		ClientMsgID:    m.ClientMsgID.String,
+		Type:           m.Type.String,
+		ConversationID: m.ConversationID,
+		SenderID:       m.SenderID,
+		Content:        m.Content.String,
+		ReplyToID:      m.ReplyToID.Int64,
+		ForwardedFrom:  m.ForwardedFrom.Int64,
+		FileName:       m.FileName.String,
+		FileID:         m.FileID.String,
+		FilePath:       m.FilePath.String,
+		FileType:       m.FileType.String,
+		FileSize:       m.FileSize.Int64,
+		CreatedAt:      m.CreatedAt.Time,
+		IsDeleted:      m.IsDeleted.Bool,
+	}
+	if m.EditedAt.Valid {
+		editedAt := m.EditedAt.Time
+		msg.EditedAt = &editedAt
+	}
+	if len(m.Mentions) > 0 {
+		if err := json.Unmarshal(m.Mentions, &msg.Mentions); err != nil {
+			log.Printf("Error decoding mentions of message %d: %v", m.ID, err)
+		}
+	}
+	if len(m.SystemPayload) > 0 {
+		msg.System = &SystemPayload{}
+		if err := json.Unmarshal(m.SystemPayload, msg.System); err != nil {
+			log.Printf("Error decoding system payload of message %d: %v", m.ID, err)
+			msg.System = nil
+		}
+	}
+
+	if msg.Type == "file" && msg.FilePath != "" {
+		signedURL, err := storage.GetPresignedURL(msg.FilePath)
+		if err != nil {
+			log.Printf("Error signing URL for message %d: %v", m.ID, err)
+		} else {
+			msg.FileURL = signedURL
+		}
+	}
+	return msg
+}
+
+const (
+	ErrCodeInvalidFrame       = "invalid_frame"
+	ErrCodeUnsupportedVersion = "unsupported_version"
+	ErrCodeUnknownType        = "unknown_type"
+	ErrCodeNotMember          = "not_member"
+	ErrCodeNotFound           = "not_found"
+	ErrCodeForbidden          = "forbidden"
+	ErrCodeLimitReached       = "limit_reached"
+	ErrCodeRateLimited        = "rate_limited"
+	ErrCodeInternal           = "internal_error"
+)
+
+// ErrorFrame is sent back to a client when one of its frames is rejected.
+type ErrorFrame struct {
+	Type           string `json:"type"`
+	Code           string `json:"code"`
+	Reason         string `json:"reason"`
+	ClientMsgID    string `json:"client_msg_id,omitempty"`
+	ConversationID int64  `json:"conversation_id,omitempty"`
+	MessageID      int64  `json:"message_id,omitempty"`
+	RequestID      string `json:"request_id,omitempty"`
+}
+
+func newErrorFrame(code, reason string, msg *Message) ErrorFrame {
+	frame := ErrorFrame{
+		Type:   "error",
+		Code:   code,
+		Reason: reason,
+	}
+	if msg != nil {
+		frame.ClientMsgID = msg.ClientMsgID
+		frame.ConversationID = msg.ConversationID
+		frame.MessageID = msg.ID
+		frame.RequestID = msg.RequestID
+	}
+	return frame
+}
+
+// AckFrame maps a client_msg_id to the outcome of persisting the message.
+type AckFrame struct {
+	Type           string     `json:"type"`
+	ClientMsgID    string     `json:"client_msg_id"`
+	ConversationID int64      `json:"conversation_id"`
+	MessageID      int64      `json:"message_id,omitempty"`
+	CreatedAt      *time.Time `json:"created_at,omitempty"`
+	Reason         string     `json:"reason,omitempty"`
+	RequestID      string     `json:"request_id,omitempty"`
+
+	// Status is the delivery status of an acked message, always "sent":
+	// it only moves on once recipients confirm with mark_as_delivered or
+	// mark_as_read, see MessageStatusSent.
+	Status string `json:"status,omitempty"`
+}
+
+// inboundFrame is a raw frame read from a client's socket.
+type inboundFrame struct {
+	client *Client
+	data   []byte
 }
 
 type Hub struct {
-	clients    map[string]*Client
+	// clients holds every open session, keyed by UserID then by Client.ID,
+	// so one user can be connected from several devices at once.
+	clients    map[string]map[string]*Client
 	register   chan *Client
 	unregister chan *Client
-	broadcast  chan []byte
+	broadcast  chan inboundFrame
 	mu         sync.RWMutex
 	q          *db.Queries
+
+	// cluster is nil when the hub only serves its own connections.
+	cluster *Cluster
+
+	typing *typingTracker
+
+	// presence is nil unless EnablePresence was called.
+	presence chan presenceUpdate
+
+	// limiter is nil unless EnableRateLimit was called.
+	limiter *rateLimiter
 }
 
 func NewHub(q *db.Queries) *Hub {
 	return &Hub{
-		clients:    make(map[string]*Client),
+		clients:    make(map[string]map[string]*Client),
 		register:   make(chan *Client),
 		unregister: make(chan *Client),
-		broadcast:  make(chan []byte),
+		broadcast:  make(chan inboundFrame),
 		q:          q,
+		typing:     newTypingTracker(),
 	}
 }
 
@@ -58,100 +203,368 @@ func (h *Hub) Run() {
 		select {
 		case client := <-h.register:
 			h.mu.Lock()
-			h.clients[client.UserID] = client
+			sessions, ok := h.clients[client.UserID]
+			if !ok {
+				sessions = make(map[string]*Client)
+				h.clients[client.UserID] = sessions
+			}
+			sessions[client.ID] = client
 			h.mu.Unlock()
-			log.Printf("User %s connected", client.UserID)
+			log.Printf("User %s connected (session %s, %d active)", client.UserID, client.ID, len(sessions))
+			if !ok {
+				log.Printf("User %s is online", client.UserID)
+				if h.cluster != nil {
+					if err := h.cluster.join(context.Background(), client.UserID); err != nil {
+						log.Printf("Cluster join failed for user %s: %v", client.UserID, err)
+					}
+				}
+				h.queuePresence(client.UserID, true)
+			}
 
 		case client := <-h.unregister:
 			h.mu.Lock()
-			if _, ok := h.clients[client.UserID]; ok {
-				delete(h.clients, client.UserID)
-				close(client.Send)
-				log.Printf("User %s disconnected", client.UserID)
+			sessions := h.clients[client.UserID]
+			current, ok := sessions[client.ID]
+			removed := ok && current == client
+			if removed {
+				delete(sessions, client.ID)
+				client.close()
+				if len(sessions) == 0 {
+					delete(h.clients, client.UserID)
+				}
 			}
 			h.mu.Unlock()
 
-		case message := <-h.broadcast:
-			h.handleMessageDelivery(message)
+			if !removed {
+				continue
+			}
+			log.Printf("User %s disconnected (session %s, %d active)", client.UserID, client.ID, len(sessions))
+			if len(sessions) == 0 {
+				log.Printf("User %s is offline", client.UserID)
+				go h.stopAllTyping(client.UserID)
+				if h.cluster != nil {
+					if err := h.cluster.leave(context.Background(), client.UserID); err != nil {
+						log.Printf("Cluster leave failed for user %s: %v", client.UserID, err)
+					}
+				}
+				h.queuePresence(client.UserID, false)
+			}
+
+		case frame := <-h.broadcast:
+			h.handleMessageDelivery(frame)
 		}
 	}
 }
 
-func (h *Hub) handleMessageDelivery(rawData []byte) {
+func (h *Hub) handleMessageDelivery(frame inboundFrame) {
 	ctx := context.Background()
-	var msg Message
-	if err := json.Unmarshal(rawData, &msg); err != nil {
-		log.Println("failed to unmarshal message: ", err)
+	client := frame.client
+
+	event, msg, ok := client.decodeFrame(frame.data)
+	if !ok {
+		return
+	}
+	if msg.ConversationID == 0 {
+		client.sendError(ErrCodeInvalidFrame, "conversation_id is required", &msg)
 		return
 	}
+	if h.limiter != nil {
+		name := eventName(msg)
+		if scope, retryAfter, ok := h.limiter.allow(name, client.UserID, msg.ConversationID, time.Now()); !ok {
+			client.rateLimited(name, scope, retryAfter, &msg)
+			return
+		}
+	}
 
-	kafkaKey := strconv.FormatInt(msg.ConversationID, 10)
-	err := broker.Get().PushEvent(ctx, config.Get().KafkaTopicPersistence, kafkaKey, msg)
+	// Never trust the identity claimed inside the frame.
+	msg.SenderID = client.UserID
+	msg.SenderName = client.UserName
+	msg.ForwardedFrom = 0
+	msg.System = nil
+
+	memberIDs, err := h.conversationMembers(ctx, msg.ConversationID)
 	if err != nil {
+		log.Printf("Failed to load participants for Conv %d: %v", msg.ConversationID, err)
+		client.sendError(ErrCodeInternal, "could not verify conversation membership", &msg)
+		return
+	}
+	if !slices.Contains(memberIDs, msg.SenderID) {
+		// The cache may predate a membership change; confirm against the DB.
+		memberIDs, err = h.refreshConversationMembers(ctx, msg.ConversationID)
+		if err != nil || !slices.Contains(memberIDs, msg.SenderID) {
+			log.Printf("Rejected frame from %s: not a member of Conv %d", msg.SenderID, msg.ConversationID)
+			client.sendError(ErrCodeNotMember, "you are not a member of this conversation", &msg)
+			return
+		}
+	}
+
+	if reason := event.validate(&msg); reason != "" {
+		client.sendError(ErrCodeInvalidFrame, reason, &msg)
+		return
+	}
+	event.handle(h, ctx, client, msg, memberIDs)
+}
+
+// enqueueMessage queues a new message for persistence. Failures are nacked
+// so the client can retry with the same client_msg_id.
+func (h *Hub) enqueueMessage(ctx context.Context, c *Client, msg Message, memberIDs []string) {
+	h.clearTyping(msg.ConversationID, msg.SenderID)
+	if reason := validateMentions(msg, memberIDs); reason != "" {
+		h.Nack(ctx, msg, reason)
+		return
+	}
+
+	failed := func(err error) {
 		log.Printf("Failed to push event persistence for Conv %d: %v", msg.ConversationID, err)
-	} else {
-		log.Printf("Pushed message to Kafka persistence: %s", msg.Content)
+		h.Nack(context.Background(), msg, "message could not be queued, please retry")
+	}
+	if err := h.Enqueue(ctx, msg, failed); err != nil {
+		failed(err)
+		return
+	}
+	// Everything is broadcast by the DB worker once persisted.
+	log.Printf("Pushed message to Kafka persistence: %s", msg.Content)
+}
+
+// enqueueEvent queues an event acting on existing messages or read state.
+func (h *Hub) enqueueEvent(ctx context.Context, c *Client, msg Message, memberIDs []string) {
+	msg.Mentions = nil
+	failed := func(err error) {
+		log.Printf("Failed to push event persistence for Conv %d: %v", msg.ConversationID, err)
+		c.sendError(ErrCodeInternal, "event could not be queued, please retry", &msg)
+	}
+	if err := h.Enqueue(ctx, msg, failed); err != nil {
+		failed(err)
+		return
 	}
+	log.Printf("Pushed %s to Kafka persistence for Conv %d", msg.Type, msg.ConversationID)
+}
 
-	if msg.Type == "mark_as_read" {
-		log.Printf("Received mark_as_read for Conv %d from %s", msg.ConversationID, msg.SenderID)
-	} else if msg.Type == "file" && msg.FilePath != "" {
+// Enqueue puts a frame on the persistence topic, keyed by conversation so
+// the DB worker handles each conversation's frames in order. The write is
+// asynchronous: onError is called if Kafka rejects the frame after Enqueue
+// returned.
+func (h *Hub) Enqueue(ctx context.Context, msg Message, onError func(error)) error {
+	kafkaKey := strconv.FormatInt(msg.ConversationID, 10)
+	return broker.Get().PushEvent(ctx, config.Get().KafkaTopicPersistence, kafkaKey, msg, onError)
+}
+
+// BroadcastMessage fans a persisted message out to every member of its
+// conversation and pushes a notification to members who are not connected.
+// System messages never trigger push notifications.
+func (h *Hub) BroadcastMessage(ctx context.Context, msg Message) {
+	if msg.Type == "file" && msg.FilePath != "" {
 		signedURL, err := storage.GetPresignedURL(msg.FilePath)
 		if err == nil {
 			msg.FileURL = signedURL
-			newRawData, err := json.Marshal(msg)
-			if err == nil {
-				rawData = newRawData
-			}
 		} else {
 			log.Printf("Error signing URL in Hub for file %s: %v", msg.FilePath, err)
 		}
 	}
 
-	convIDStr := strconv.FormatInt(msg.ConversationID, 10)
-	memberIDs, err := db.GetCachedParticipants(ctx, convIDStr)
+	memberIDs, err := h.conversationMembers(ctx, msg.ConversationID)
+	if err != nil {
+		log.Printf("Failed to load participants for Conv %d: %v", msg.ConversationID, err)
+		return
+	}
 
-	if err != nil || len(memberIDs) == 0 {
-		log.Println("Cache miss for participants, fetching from DB...")
-		rows, err := h.q.ListParticipantsByConversation(ctx, msg.ConversationID)
-		if err == nil {
-			for _, r := range rows {
-				memberIDs = append(memberIDs, r.UserID)
-			}
-			db.CacheParticipants(ctx, convIDStr, memberIDs)
-		}
+	// The request id only concerns the sender's ack.
+	msg.RequestID = ""
+	rawData, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("Failed to encode message for Conv %d: %v", msg.ConversationID, err)
+		return
 	}
+	frame := newOutboundFrame(rawData)
 
+	var muted []string
+	mutedLoaded := false
 	for _, memberID := range memberIDs {
-		h.mu.RLock()
-		client, online := h.clients[memberID]
-		h.mu.RUnlock()
-
-		if online {
-			select {
-			case client.Send <- rawData:
-				log.Printf("Delivered message to %s (Online)", memberID)
-			default:
-				h.unregister <- client
-				if memberID != msg.SenderID {
-					h.sendToPushTopic(ctx, memberID, msg)
+		if !h.deliverFrame(ctx, memberID, frame) && memberID != msg.SenderID && msg.Type != MessageTypeSystem {
+			if !mutedLoaded {
+				if muted, err = h.q.ListMutedParticipants(ctx, msg.ConversationID); err != nil {
+					log.Printf("Failed to load muted members of Conv %d: %v", msg.ConversationID, err)
 				}
+				mutedLoaded = true
 			}
-		} else {
-			if memberID != msg.SenderID {
-				h.sendToPushTopic(ctx, memberID, msg)
-			}
+			h.sendToPushTopic(ctx, memberID, msg, slices.Contains(muted, memberID))
 		}
 	}
 }
 
-func (h *Hub) sendToPushTopic(ctx context.Context, userID string, msg Message) {
+// SendError delivers an error frame to every session of the user, on any
+// instance. It is used when a frame is rejected after leaving the hub.
+func (h *Hub) SendError(ctx context.Context, userID, code, reason string, msg *Message) {
+	data, err := json.Marshal(newErrorFrame(code, reason, msg))
+	if err != nil {
+		return
+	}
+	h.deliver(ctx, userID, data)
+}
+
+// Ack tells all of the sender's sessions that the message was persisted.
+// System messages were not sent by a client and are never acknowledged.
+func (h *Hub) Ack(ctx context.Context, msg Message) {
+	if msg.Type == MessageTypeSystem {
+		return
+	}
+	createdAt := msg.CreatedAt
+	h.sendAck(ctx, msg.SenderID, AckFrame{
+		Type:           "ack",
+		ClientMsgID:    msg.ClientMsgID,
+		ConversationID: msg.ConversationID,
+		MessageID:      msg.ID,
+		CreatedAt:      &createdAt,
+		RequestID:      msg.RequestID,
+		Status:         MessageStatusSent,
+	})
+}
+
+// Nack tells all of the sender's sessions that the message was not accepted.
+func (h *Hub) Nack(ctx context.Context, msg Message, reason string) {
+	if msg.Type == MessageTypeSystem {
+		log.Printf("System message for Conv %d dropped: %s", msg.ConversationID, reason)
+		return
+	}
+	h.sendAck(ctx, msg.SenderID, AckFrame{
+		Type:           "nack",
+		ClientMsgID:    msg.ClientMsgID,
+		ConversationID: msg.ConversationID,
+		Reason:         reason,
+		RequestID:      msg.RequestID,
+	})
+}
+
+func (h *Hub) sendAck(ctx context.Context, userID string, frame AckFrame) {
+	// Without a client_msg_id the client has nothing to match the ack against.
+	if frame.ClientMsgID == "" {
+		return
+	}
+
+	data, err := json.Marshal(frame)
+	if err != nil {
+		return
+	}
+	h.deliver(ctx, userID, data)
+}
+
+// IsMember reports whether the user takes part in the conversation,
+// confirming against the DB when the cache says no.
+func (h *Hub) IsMember(ctx context.Context, convID int64, userID string) (bool, error) {
+	memberIDs, err := h.conversationMembers(ctx, convID)
+	if err == nil && slices.Contains(memberIDs, userID) {
+		return true, nil
+	}
+	memberIDs, err = h.refreshConversationMembers(ctx, convID)
+	if err != nil {
+		return false, err
+	}
+	return slices.Contains(memberIDs, userID), nil
+}
+
+// conversationMembers returns the participant IDs of a conversation, served
+// from the Redis cache when possible.
+func (h *Hub) conversationMembers(ctx context.Context, convID int64) ([]string, error) {
+	memberIDs, err := db.GetCachedParticipants(ctx, strconv.FormatInt(convID, 10))
+	if err == nil && len(memberIDs) > 0 {
+		return memberIDs, nil
+	}
+
+	log.Println("Cache miss for participants, fetching from DB...")
+	return h.refreshConversationMembers(ctx, convID)
+}
+
+// refreshConversationMembers loads the participants from the DB and rewrites
+// the Redis cache.
+func (h *Hub) refreshConversationMembers(ctx context.Context, convID int64) ([]string, error) {
+	rows, err := h.q.ListParticipantsByConversation(ctx, convID)
+	if err != nil {
+		return nil, err
+	}
+
+	memberIDs := make([]string, 0, len(rows))
+	for _, r := range rows {
+		memberIDs = append(memberIDs, r.UserID)
+	}
+	if len(memberIDs) > 0 {
+		db.CacheParticipants(ctx, strconv.FormatInt(convID, 10), memberIDs)
+	}
+	return memberIDs, nil
+}
+
+// deliver sends data to every session of the user, on this instance or, when
+// clustering is enabled, on any other. It reports whether the user is
+// connected anywhere.
+func (h *Hub) deliver(ctx context.Context, userID string, data []byte) bool {
+	return h.deliverFrame(ctx, userID, newOutboundFrame(data))
+}
+
+// deliverFrame is deliver for frames fanned out to several users.
+func (h *Hub) deliverFrame(ctx context.Context, userID string, frame *outboundFrame) bool {
+	if h.cluster != nil {
+		receivers, err := h.cluster.publish(ctx, userID, frame.data)
+		if err == nil {
+			return receivers > 0
+		}
+		log.Printf("Cluster publish failed for user %s, delivering locally: %v", userID, err)
+	}
+	return h.deliverLocal(userID, frame)
+}
+
+// deliverLocal writes the frame to the user's sessions held by this instance.
+// It never blocks: slow sessions are handled by their own policy, and
+// closed ones are unregistered by their ReadPump.
+func (h *Hub) deliverLocal(userID string, frame *outboundFrame) bool {
+	delivered := false
+	for _, client := range h.sessions(userID) {
+		if client.trySendFrame(frame) {
+			delivered = true
+			log.Printf("Delivered message to %s (session %s)", userID, client.ID)
+		}
+	}
+	return delivered
+}
+
+// sessions returns a snapshot of the user's open connections.
+func (h *Hub) sessions(userID string) []*Client {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	sessions := make([]*Client, 0, len(h.clients[userID]))
+	for _, c := range h.clients[userID] {
+		sessions = append(sessions, c)
+	}
+	return sessions
+}
+
+// IsOnline reports whether the user has at least one open session on this hub.
+func (h *Hub) IsOnline(userID string) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.clients[userID]) > 0
+}
+
+// sendToPushTopic queues a push notification unless the user muted the
+// conversation. Mentions get through regardless.
+func (h *Hub) sendToPushTopic(ctx context.Context, userID string, msg Message, muted bool) {
+	mentioned := msg.mentions(userID)
+	if muted && !mentioned {
+		return
+	}
+
 	pushPayload := map[string]interface{}{
-		"receiver_id": userID,
-		"content":     msg.Content,
-		"type":        msg.Type,
-		"sender_id":   msg.SenderID,
-		"sender_name": msg.SenderName,
+		"receiver_id":       userID,
+		"content":           msg.Content,
+		"type":              msg.Type,
+		"sender_id":         msg.SenderID,
+		"sender_name":       msg.SenderName,
+		"conversation_id":   msg.ConversationID,
+		"message_id":        msg.ID,
+		"notification_type": "message",
+	}
+	if mentioned {
+		pushPayload["notification_type"] = "mention"
+		pushPayload["bypass_mute"] = true
 	}
-	_ = broker.Get().PushEvent(ctx, config.Get().KafkaTopicNotification, userID, pushPayload)
+	_ = broker.Get().PushEvent(ctx, config.Get().KafkaTopicNotification, userID, pushPayload, nil)
 }
@@ -0,0 +1,157 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func newTestClient(h *Hub, userID, connID string) *Client {
+	return &Client{
+		ID:     connID,
+		UserID: userID,
+		Hub:    h,
+		Send:   make(chan []byte, 8),
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met before timeout")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// nextFrame returns the next frame queued for c, or nil if there is none.
+func nextFrame(c *Client) []byte {
+	select {
+	case data := <-c.Send:
+		return data
+	default:
+		return nil
+	}
+}
+
+func TestHubMultipleSessions(t *testing.T) {
+	h := NewHub(nil)
+	go h.Run()
+
+	web := newTestClient(h, "u1", "web")
+	mobile := newTestClient(h, "u1", "mobile")
+
+	h.register <- web
+	h.register <- mobile
+	waitFor(t, func() bool { return len(h.sessions("u1")) == 2 })
+
+	for _, c := range h.sessions("u1") {
+		if !c.trySend([]byte("hello")) {
+			t.Fatalf("session %s did not accept message", c.ID)
+		}
+	}
+	for _, c := range []*Client{web, mobile} {
+		if got := string(<-c.Send); got != "hello" {
+			t.Errorf("session %s got %q", c.ID, got)
+		}
+	}
+
+	h.unregister <- web
+	waitFor(t, func() bool { return len(h.sessions("u1")) == 1 })
+	if !h.IsOnline("u1") {
+		t.Fatal("user should stay online while a session remains")
+	}
+	if _, ok := <-web.Send; ok {
+		t.Error("web session Send should be closed")
+	}
+	if !mobile.trySend([]byte("still here")) {
+		t.Error("mobile session must not be closed by web unregister")
+	}
+
+	// A stale unregister for an already removed session is a no-op.
+	h.unregister <- web
+	h.unregister <- mobile
+	waitFor(t, func() bool { return !h.IsOnline("u1") })
+}
+
+func TestFramesRequireMembership(t *testing.T) {
+	s := newTestService(t)
+	convID, users := newTestConversation(t, s, "alice")
+	alice := users[0]
+
+	h := NewHub(s.queries)
+	aliceWeb := newTestClient(h, alice, "web")
+	h.clients[alice] = map[string]*Client{aliceWeb.ID: aliceWeb}
+	mallory := newTestClient(h, testUserID("mallory"), "web")
+
+	tests := []struct {
+		name  string
+		frame Message
+	}{
+		{"outsider", Message{Type: "text", ConversationID: convID, Content: "hi"}},
+		{"outsider claiming a member's identity", Message{Type: "text", ConversationID: convID, SenderID: alice, SenderName: "Alice", Content: "hi"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, _ := json.Marshal(tt.frame)
+			h.handleMessageDelivery(inboundFrame{client: mallory, data: data})
+
+			var got ErrorFrame
+			json.Unmarshal(nextFrame(mallory), &got)
+			if got.Code != ErrCodeNotMember {
+				t.Errorf("sender got error %q, want %q", got.Code, ErrCodeNotMember)
+			}
+			if data := nextFrame(aliceWeb); data != nil {
+				t.Errorf("member received rejected frame: %s", data)
+			}
+		})
+	}
+}
+
+func TestAckAndBroadcastCarryServerID(t *testing.T) {
+	s := newTestService(t)
+	ctx := context.Background()
+	convID, users := newTestConversation(t, s, "alice", "bob")
+	alice, bob := users[0], users[1]
+
+	h := NewHub(s.queries)
+	aliceWeb := newTestClient(h, alice, "web")
+	aliceMobile := newTestClient(h, alice, "mobile")
+	bobWeb := newTestClient(h, bob, "web")
+	h.clients[alice] = map[string]*Client{aliceWeb.ID: aliceWeb, aliceMobile.ID: aliceMobile}
+	h.clients[bob] = map[string]*Client{bobWeb.ID: bobWeb}
+
+	msg := Message{
+		ID:             42,
+		ClientMsgID:    "c1",
+		Type:           "text",
+		ConversationID: convID,
+		SenderID:       alice,
+		Content:        "hi",
+		CreatedAt:      time.Now().UTC(),
+	}
+
+	h.Ack(ctx, msg)
+	for _, c := range []*Client{aliceWeb, aliceMobile} {
+		var ack AckFrame
+		json.Unmarshal(nextFrame(c), &ack)
+		if ack.Type != "ack" || ack.ClientMsgID != "c1" || ack.MessageID != 42 {
+			t.Errorf("session %s got %+v, want an ack of c1 as message 42", c.ID, ack)
+		}
+	}
+	if nextFrame(bobWeb) != nil {
+		t.Error("ack reached a member other than the sender")
+	}
+
+	h.BroadcastMessage(ctx, msg)
+	for _, c := range []*Client{aliceWeb, aliceMobile, bobWeb} {
+		var got Message
+		json.Unmarshal(nextFrame(c), &got)
+		if got.ID != 42 || got.ClientMsgID != "c1" {
+			t.Errorf("session %s of %s got %+v, want message 42", c.ID, c.UserID, got)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package chat
+
+import (
+	"fmt"
+	"slices"
+	"unicode/utf8"
+)
+
+const (
+	// MentionAll is the user_id of an @all mention.
+	MentionAll = "all"
+
+	maxMentions = 50
+)
+
+// Mention marks a range of the message content as referring to a user.
+// Offset and Length count Unicode code points.
+type Mention struct {
+	UserID string `json:"user_id"`
+	Offset int    `json:"offset"`
+	Length int    `json:"length"`
+}
+
+// validateMentions checks every mention points at a participant and lies
+// within the content. It returns the reason the frame must be rejected, or
+// an empty string.
+func validateMentions(msg Message, memberIDs []string) string {
+	if len(msg.Mentions) > maxMentions {
+		return fmt.Sprintf("at most %d mentions per message", maxMentions)
+	}
+
+	contentLen := utf8.RuneCountInString(msg.Content)
+	for _, m := range msg.Mentions {
+		if m.UserID != MentionAll && !slices.Contains(memberIDs, m.UserID) {
+			return fmt.Sprintf("mentioned user %s is not a member of this conversation", m.UserID)
+		}
+		if m.Offset < 0 || m.Length <= 0 || m.Offset+m.Length > contentLen {
+			return "mention is out of the content bounds"
+		}
+	}
+	return ""
+}
+
+// mentions reports whether the message mentions the user, directly or
+// through @all.
+func (m Message) mentions(userID string) bool {
+	return slices.ContainsFunc(m.Mentions, func(mn Mention) bool {
+		return mn.UserID == userID || mn.UserID == MentionAll
+	})
+}
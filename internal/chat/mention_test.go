@@ -0,0 +1,37 @@
+package chat
+
+import "testing"
+
+func TestValidateMentions(t *testing.T) {
+	members := []string{"alice", "bob"}
+	tooMany := make([]Mention, maxMentions+1)
+	for i := range tooMany {
+		tooMany[i] = Mention{UserID: "bob", Offset: 0, Length: 1}
+	}
+
+	tests := []struct {
+		name     string
+		content  string
+		mentions []Mention
+		want     string
+	}{
+		{"no mentions", "hi", nil, ""},
+		{"member", "hi @bob", []Mention{{UserID: "bob", Offset: 3, Length: 4}}, ""},
+		{"all", "@all look", []Mention{{UserID: MentionAll, Offset: 0, Length: 4}}, ""},
+		{"counts code points", "héllo @bob", []Mention{{UserID: "bob", Offset: 6, Length: 4}}, ""},
+		{"emoji counts once", "👋 @bob", []Mention{{UserID: "bob", Offset: 2, Length: 4}}, ""},
+		{"not a member", "hi @carol", []Mention{{UserID: "carol", Offset: 3, Length: 6}}, "mentioned user carol is not a member of this conversation"},
+		{"past the end", "hi @bob", []Mention{{UserID: "bob", Offset: 3, Length: 5}}, "mention is out of the content bounds"},
+		{"negative offset", "hi @bob", []Mention{{UserID: "bob", Offset: -1, Length: 4}}, "mention is out of the content bounds"},
+		{"empty range", "hi @bob", []Mention{{UserID: "bob", Offset: 3, Length: 0}}, "mention is out of the content bounds"},
+		{"too many", "b", tooMany, "at most 50 mentions per message"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := Message{Content: tt.content, Mentions: tt.mentions}
+			if got := validateMentions(msg, members); got != tt.want {
+				t.Errorf("validateMentions() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
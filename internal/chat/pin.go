@@ -0,0 +1,196 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+
+	"corechain-communication/internal/db"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const maxPinnedMessages = 20
+
+var (
+	ErrPinNotAllowed   = errors.New("only group admins can pin messages")
+	ErrPinLimitReached = fmt.Errorf("a conversation can have at most %d pinned messages", maxPinnedMessages)
+)
+
+// PinEvent is broadcast to conversation members when a message is pinned
+// or unpinned.
+type PinEvent struct {
+	Type           string `json:"type"`
+	ConversationID int64  `json:"conversation_id"`
+	MessageID      int64  `json:"message_id"`
+	UserID         string `json:"user_id"`
+}
+
+// checkPinPermission lets any participant of a private chat manage pins,
+// but only admins of a group.
+func checkPinPermission(ctx context.Context, q *db.Queries, conversationID int64, userID string) error {
+	conv, err := q.GetConversationByID(ctx, conversationID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrNotMember
+	}
+	if err != nil {
+		return err
+	}
+
+	participants, err := q.ListParticipantsByConversation(ctx, conversationID)
+	if err != nil {
+		return err
+	}
+	for _, p := range participants {
+		if p.UserID != userID {
+			continue
+		}
+		if conv.IsGroup.Bool && p.Role.String != RoleAdmin {
+			return ErrPinNotAllowed
+		}
+		return nil
+	}
+	return ErrNotMember
+}
+
+// PinMessage pins a message and records a message_pinned system message
+// about it. The system message is nil when the message was already pinned.
+func PinMessage(ctx context.Context, pool *pgxpool.Pool, q *db.Queries, userID, userName string, conversationID, messageID int64) (*db.Message, error) {
+	if err := checkPinPermission(ctx, q, conversationID, userID); err != nil {
+		return nil, err
+	}
+
+	target, err := q.GetMessageByID(ctx, db.GetMessageByIDParams{
+		ID:             messageID,
+		ConversationID: conversationID,
+	})
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && target.IsDeleted.Bool) {
+		return nil, ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	qtx := q.WithTx(tx)
+
+	// Concurrent pins of the conversation wait here, so each one counts
+	// the pins committed before it.
+	if err := qtx.LockConversation(ctx, conversationID); err != nil {
+		return nil, err
+	}
+	// Pinning again is a no-op, even once the limit is reached.
+	already, err := qtx.IsMessagePinned(ctx, db.IsMessagePinnedParams{
+		ConversationID: conversationID,
+		MessageID:      messageID,
+	})
+	if err != nil || already {
+		return nil, err
+	}
+	count, err := qtx.CountPinnedMessages(ctx, conversationID)
+	if err != nil {
+		return nil, err
+	}
+	if count >= maxPinnedMessages {
+		return nil, ErrPinLimitReached
+	}
+
+	pinned, err := qtx.PinMessage(ctx, db.PinMessageParams{
+		ConversationID: conversationID,
+		MessageID:      messageID,
+		PinnedBy:       userID,
+	})
+	if err != nil || pinned == 0 {
+		return nil, err
+	}
+
+	// The notice points at the pinned message through its payload, not
+	// reply_to_id, so it never shows up in the message's thread.
+	payload := SystemPayload{
+		Action:    SystemMessagePinned,
+		Actor:     SystemUser{ID: userID, Name: userName},
+		MessageID: messageID,
+	}
+	encoded, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	system, err := qtx.CreateMessage(ctx, db.CreateMessageParams{
+		ConversationID: conversationID,
+		SenderID:       userID,
+		Content:        pgtype.Text{String: payload.Render(), Valid: true},
+		Type:           pgtype.Text{String: MessageTypeSystem, Valid: true},
+		SystemPayload:  encoded,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = qtx.UpdateConversationLastMessage(ctx, db.UpdateConversationLastMessageParams{
+		ID:            conversationID,
+		LastMessageID: pgtype.Int8{Int64: system.ID, Valid: true},
+		LastMessageAt: system.CreatedAt,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return &system, nil
+}
+
+// UnpinMessage reports whether the message was pinned.
+func UnpinMessage(ctx context.Context, q *db.Queries, userID string, conversationID, messageID int64) (bool, error) {
+	if err := checkPinPermission(ctx, q, conversationID, userID); err != nil {
+		return false, err
+	}
+
+	n, err := q.UnpinMessage(ctx, db.UnpinMessageParams{
+		ConversationID: conversationID,
+		MessageID:      messageID,
+	})
+	return n > 0, err
+}
+
+// BroadcastPin tells the members about a pin change and, for a new pin,
+// delivers the system message. Neither triggers push notifications.
+func (h *Hub) BroadcastPin(ctx context.Context, eventType, userID string, conversationID, messageID int64, system *db.Message) {
+	memberIDs, err := h.conversationMembers(ctx, conversationID)
+	if err != nil {
+		log.Printf("Failed to load participants for Conv %d: %v", conversationID, err)
+		return
+	}
+
+	frames := make([]*outboundFrame, 0, 2)
+	if data, err := json.Marshal(PinEvent{
+		Type:           eventType,
+		ConversationID: conversationID,
+		MessageID:      messageID,
+		UserID:         userID,
+	}); err == nil {
+		frames = append(frames, newOutboundFrame(data))
+	}
+	if system != nil {
+		msg := h.messagesFromDB(ctx, []db.Message{*system})[0]
+		if data, err := json.Marshal(msg); err == nil {
+			frames = append(frames, newOutboundFrame(data))
+		}
+	}
+
+	for _, memberID := range memberIDs {
+		for _, frame := range frames {
+			h.deliverFrame(ctx, memberID, frame)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"corechain-communication/internal/db"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const maxPinnedConversations = 5
+
+var (
+	ErrPinnedConversationsLimit = fmt.Errorf("at most %d conversations can be pinned", maxPinnedConversations)
+	ErrInvalidMuteTime          = errors.New("muted_until must be in the future")
+)
+
+// ConversationPreferences are a user's own settings for a conversation.
+// A muted conversation without MutedUntil stays muted until unmuted.
+type ConversationPreferences struct {
+	Type           string     `json:"type,omitempty"`
+	ConversationID int64      `json:"conversation_id"`
+	IsMuted        bool       `json:"is_muted"`
+	MutedUntil     *time.Time `json:"muted_until,omitempty"`
+	IsArchived     bool       `json:"is_archived"`
+	IsPinned       bool       `json:"is_pinned"`
+	PinnedAt       *time.Time `json:"pinned_at,omitempty"`
+}
+
+func newConversationPreferences(conversationID int64, mutedUntil pgtype.Timestamptz, archived bool, pinnedAt pgtype.Timestamptz) ConversationPreferences {
+	prefs := ConversationPreferences{
+		ConversationID: conversationID,
+		IsMuted:        isMuted(mutedUntil),
+		IsArchived:     archived,
+		IsPinned:       pinnedAt.Valid,
+	}
+	if prefs.IsMuted && mutedUntil.InfinityModifier == pgtype.Finite {
+		t := mutedUntil.Time
+		prefs.MutedUntil = &t
+	}
+	if pinnedAt.Valid {
+		t := pinnedAt.Time
+		prefs.PinnedAt = &t
+	}
+	return prefs
+}
+
+// isMuted reports whether a muted_until value is still in effect. Muting
+// without an end is stored as infinity.
+func isMuted(until pgtype.Timestamptz) bool {
+	if !until.Valid {
+		return false
+	}
+	return until.InfinityModifier == pgtype.Infinity || until.Time.After(time.Now())
+}
+
+// MuteConversation silences push notifications, except mentions, until the
+// given time, or indefinitely when until is nil.
+func (s *ChatService) MuteConversation(ctx context.Context, userID string, conversationID int64, until *time.Time) (ConversationPreferences, error) {
+	mutedUntil := pgtype.Timestamptz{InfinityModifier: pgtype.Infinity, Valid: true}
+	if until != nil {
+		if !until.After(time.Now()) {
+			return ConversationPreferences{}, ErrInvalidMuteTime
+		}
+		mutedUntil = pgtype.Timestamptz{Time: until.UTC(), Valid: true}
+	}
+
+	p, err := s.queries.SetConversationMute(ctx, db.SetConversationMuteParams{
+		MutedUntil:     mutedUntil,
+		ConversationID: conversationID,
+		UserID:         userID,
+	})
+	return participantPreferences(p, err)
+}
+
+func (s *ChatService) UnmuteConversation(ctx context.Context, userID string, conversationID int64) (ConversationPreferences, error) {
+	p, err := s.queries.SetConversationMute(ctx, db.SetConversationMuteParams{
+		ConversationID: conversationID,
+		UserID:         userID,
+	})
+	return participantPreferences(p, err)
+}
+
+func (s *ChatService) ArchiveConversation(ctx context.Context, userID string, conversationID int64, archived bool) (ConversationPreferences, error) {
+	p, err := s.queries.SetConversationArchived(ctx, db.SetConversationArchivedParams{
+		IsArchived:     archived,
+		ConversationID: conversationID,
+		UserID:         userID,
+	})
+	return participantPreferences(p, err)
+}
+
+func (s *ChatService) PinConversation(ctx context.Context, userID string, conversationID int64, pinned bool) (ConversationPreferences, error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return ConversationPreferences{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	qtx := s.queries.WithTx(tx)
+
+	var pinnedAt pgtype.Timestamptz
+	if pinned {
+		// Concurrent pins by the same user wait here, so each one counts
+		// the pins committed before it.
+		if err := qtx.LockUserParticipants(ctx, userID); err != nil {
+			return ConversationPreferences{}, err
+		}
+		// Pinning again moves the conversation back to the top.
+		count, err := qtx.CountPinnedConversations(ctx, db.CountPinnedConversationsParams{
+			UserID:         userID,
+			ConversationID: conversationID,
+		})
+		if err != nil {
+			return ConversationPreferences{}, err
+		}
+		if count >= maxPinnedConversations {
+			return ConversationPreferences{}, ErrPinnedConversationsLimit
+		}
+		pinnedAt = pgtype.Timestamptz{Time: time.Now().UTC(), Valid: true}
+	}
+
+	p, err := qtx.SetConversationPinned(ctx, db.SetConversationPinnedParams{
+		PinnedAt:       pinnedAt,
+		ConversationID: conversationID,
+		UserID:         userID,
+	})
+	prefs, err := participantPreferences(p, err)
+	if err != nil {
+		return ConversationPreferences{}, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return ConversationPreferences{}, err
+	}
+	return prefs, nil
+}
+
+func participantPreferences(p db.Participant, err error) (ConversationPreferences, error) {
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ConversationPreferences{}, ErrNotMember
+	}
+	if err != nil {
+		return ConversationPreferences{}, err
+	}
+	return newConversationPreferences(p.ConversationID, p.MutedUntil, p.IsArchived, p.PinnedAt), nil
+}
+
+// BroadcastPreferences keeps the user's other devices in sync. Preferences
+// are private, so nobody else is told.
+func (h *Hub) BroadcastPreferences(ctx context.Context, userID string, prefs ConversationPreferences) {
+	prefs.Type = "conversation_preferences"
+	data, err := json.Marshal(prefs)
+	if err != nil {
+		return
+	}
+	h.deliver(ctx, userID, data)
+}
@@ -0,0 +1,152 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"corechain-communication/internal/db"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const presenceQueueSize = 1024
+
+// presenceRefreshInterval bounds how often one connection's pongs extend the
+// online status, so unsolicited pongs cannot flood Redis.
+const presenceRefreshInterval = db.UserStatusTTL / 3
+
+// PresenceEvent is pushed to everyone sharing a conversation with a user
+// whose status changed.
+type PresenceEvent struct {
+	Type       string     `json:"type"`
+	UserID     string     `json:"user_id"`
+	Online     bool       `json:"online"`
+	LastSeenAt *time.Time `json:"last_seen_at,omitempty"`
+}
+
+type presenceUpdate struct {
+	userID string
+	online bool
+	// refresh only extends the status of a user who is already online.
+	refresh bool
+}
+
+// EnablePresence makes the hub maintain online status and last-seen in
+// Redis and Postgres. It must be called before Run.
+func (h *Hub) EnablePresence() {
+	h.presence = make(chan presenceUpdate, presenceQueueSize)
+	go h.runPresence()
+}
+
+// The hub loop only queues presence updates; they are applied in order by a
+// single goroutine so Redis and DB round trips never stall delivery.
+func (h *Hub) queuePresence(userID string, online bool) {
+	h.pushPresence(presenceUpdate{userID: userID, online: online})
+}
+
+func (h *Hub) pushPresence(u presenceUpdate) {
+	if h.presence == nil {
+		return
+	}
+	select {
+	case h.presence <- u:
+	default:
+		log.Printf("Presence queue full, dropping update for user %s", u.userID)
+	}
+}
+
+func (h *Hub) runPresence() {
+	for u := range h.presence {
+		switch {
+		case u.refresh:
+			h.extendPresence(u.userID)
+		case u.online:
+			h.userOnline(u.userID)
+		default:
+			h.userOffline(u.userID)
+		}
+	}
+}
+
+// refreshPresence queues an extension of the client's online status. It is
+// called on every pong, from the client's read goroutine.
+func (h *Hub) refreshPresence(c *Client) {
+	if h.presence == nil || time.Since(c.lastPresenceRefresh) < presenceRefreshInterval {
+		return
+	}
+	c.lastPresenceRefresh = time.Now()
+	h.pushPresence(presenceUpdate{userID: c.UserID, online: true, refresh: true})
+}
+
+func (h *Hub) extendPresence(userID string) {
+	if !h.IsOnline(userID) {
+		// The pong raced with the last session closing.
+		return
+	}
+	if err := db.SetUserOnline(context.Background(), userID); err != nil {
+		log.Printf("Failed to refresh presence for user %s: %v", userID, err)
+	}
+}
+
+func (h *Hub) userOnline(userID string) {
+	ctx := context.Background()
+
+	// Another instance may already hold a session for this user.
+	wasOnline := db.IsUserOnline(ctx, userID)
+	if err := db.SetUserOnline(ctx, userID); err != nil {
+		log.Printf("Failed to set user %s online: %v", userID, err)
+		return
+	}
+	if !wasOnline {
+		h.broadcastPresence(ctx, PresenceEvent{Type: "presence_changed", UserID: userID, Online: true})
+	}
+}
+
+func (h *Hub) userOffline(userID string) {
+	ctx := context.Background()
+
+	if h.IsOnline(userID) {
+		// Reconnected before this update was processed.
+		return
+	}
+	if h.cluster != nil {
+		nodes, err := h.cluster.UserNodes(ctx, userID)
+		if err == nil && len(nodes) > 0 {
+			return
+		}
+	}
+
+	if err := db.SetUserOffline(ctx, userID); err != nil {
+		log.Printf("Failed to set user %s offline: %v", userID, err)
+	}
+
+	now := time.Now().UTC()
+	err := h.q.UpsertUserLastSeen(ctx, db.UpsertUserLastSeenParams{
+		UserID:     userID,
+		LastSeenAt: pgtype.Timestamptz{Time: now, Valid: true},
+	})
+	if err != nil {
+		log.Printf("Failed to persist last seen for user %s: %v", userID, err)
+	}
+
+	h.broadcastPresence(ctx, PresenceEvent{Type: "presence_changed", UserID: userID, Online: false, LastSeenAt: &now})
+}
+
+func (h *Hub) broadcastPresence(ctx context.Context, event PresenceEvent) {
+	partnerIDs, err := h.q.ListConversationPartnerIDs(ctx, event.UserID)
+	if err != nil {
+		log.Printf("Failed to list conversation partners of user %s: %v", event.UserID, err)
+		return
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	frame := newOutboundFrame(data)
+	for _, id := range partnerIDs {
+		h.deliverFrame(ctx, id, frame)
+	}
+}
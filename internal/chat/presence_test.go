@@ -0,0 +1,56 @@
+package chat
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"corechain-communication/internal/db"
+)
+
+func TestPongRefreshIsThrottled(t *testing.T) {
+	h := NewHub(nil)
+	h.presence = make(chan presenceUpdate, 4)
+	c := newTestClient(h, "u1", "web")
+
+	h.refreshPresence(c)
+	h.refreshPresence(c)
+	if got := len(h.presence); got != 1 {
+		t.Fatalf("queued %d refreshes for two quick pongs, want 1", got)
+	}
+	if u := <-h.presence; !u.refresh || u.userID != "u1" {
+		t.Errorf("queued %+v, want a refresh for u1", u)
+	}
+
+	c.lastPresenceRefresh = time.Now().Add(-presenceRefreshInterval)
+	h.refreshPresence(c)
+	if got := len(h.presence); got != 1 {
+		t.Errorf("queued %d refreshes after the interval, want 1", got)
+	}
+}
+
+func TestPresenceIsLimitedToPartners(t *testing.T) {
+	s := newTestService(t)
+	ctx := context.Background()
+	if err := db.GetRedis().Ping(ctx).Err(); err != nil {
+		t.Skipf("redis not available: %v", err)
+	}
+	_, users := newTestConversation(t, s, "alice", "bob")
+	alice, bob := users[0], users[1]
+	_, strangers := newTestConversation(t, s, "carol")
+	carol := strangers[0]
+
+	for _, id := range []string{bob, carol} {
+		if err := db.SetUserOnline(ctx, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := s.GetPresence(ctx, alice, []string{bob, carol, bob})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].UserID != bob || !got[0].Online {
+		t.Errorf("GetPresence() = %+v, want only %s online", got, bob)
+	}
+}
@@ -0,0 +1,293 @@
+package chat
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/gorilla/websocket"
+	"github.com/tinylib/msgp/msgp"
+)
+
+const (
+	// ProtocolVersion is the envelope version spoken on SubprotocolV1.
+	ProtocolVersion = 1
+
+	// SubprotocolV1 selects the envelope protocol via Sec-WebSocket-Protocol.
+	// Connections that offer no subprotocol keep speaking the legacy flat
+	// JSON frames while existing clients migrate.
+	SubprotocolV1 = "corechain.chat.v1"
+
+	// SubprotocolV1MsgPack carries the same envelopes encoded as
+	// MessagePack in binary frames, for clients on constrained networks.
+	SubprotocolV1MsgPack = "corechain.chat.v1.msgpack"
+)
+
+// subprotocols lists the protocols the server negotiates, JSON first.
+var subprotocols = []string{SubprotocolV1, SubprotocolV1MsgPack}
+
+// Envelope wraps every frame of the versioned protocol, in both directions.
+// ID is chosen by the client and echoed on the ack or error frames that
+// answer it.
+type Envelope struct {
+	V       int             `json:"v"`
+	Type    string          `json:"type"`
+	ID      string          `json:"id,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// eventSendMessage creates a message. Its payload carries the content type
+// ("text", "file", ...) in its own type field, "text" by default. Legacy
+// frames with a type that is not a registered event are treated the same.
+const eventSendMessage = "send_message"
+
+// inboundEvent is the registry entry of a frame type clients may send.
+type inboundEvent struct {
+	// validate returns why a frame is malformed, or "" when it is not.
+	validate func(msg *Message) string
+	handle   func(h *Hub, ctx context.Context, c *Client, msg Message, memberIDs []string)
+}
+
+var inboundEvents = map[string]inboundEvent{
+	eventSendMessage:    {validate: validateSendMessage, handle: (*Hub).enqueueMessage},
+	"edit_message":      {validate: validateEdit, handle: (*Hub).enqueueEvent},
+	"mark_as_read":      {validate: validateReadPosition, handle: (*Hub).enqueueEvent},
+	"mark_as_delivered": {validate: validateDeliveredPosition, handle: (*Hub).enqueueEvent},
+	"pin_message":       {validate: requireMessageID, handle: (*Hub).enqueueEvent},
+	"unpin_message":     {validate: requireMessageID, handle: (*Hub).enqueueEvent},
+	"add_reaction":      {validate: validateReaction, handle: (*Hub).enqueueEvent},
+	"remove_reaction":   {validate: validateReaction, handle: (*Hub).enqueueEvent},
+	"typing_start":      {validate: noValidation, handle: (*Hub).typingEvent},
+	"typing_stop":       {validate: noValidation, handle: (*Hub).typingEvent},
+}
+
+// serverEvents are the frame types the server sends besides chat messages.
+// Any other outgoing frame is a message and travels as type "message" in
+// an envelope, so new server events must be listed here.
+var serverEvents = map[string]bool{
+	"ack":                      true,
+	"nack":                     true,
+	"error":                    true,
+	"typing_start":             true,
+	"typing_stop":              true,
+	"presence_changed":         true,
+	"read_receipt":             true,
+	"delivered":                true,
+	"message_edited":           true,
+	"message_deleted":          true,
+	"message_pinned":           true,
+	"message_unpinned":         true,
+	"reaction_added":           true,
+	"reaction_removed":         true,
+	"conversation_updated":     true,
+	"conversation_preferences": true,
+	"sync_complete":            true,
+	"resync_required":          true,
+}
+
+// decodeFrame reads a client frame into the event it asks for. Malformed
+// frames are answered with an error frame and ok is false.
+func (c *Client) decodeFrame(data []byte) (event inboundEvent, msg Message, ok bool) {
+	if c.Protocol == "" {
+		if err := json.Unmarshal(data, &msg); err != nil {
+			c.sendError(ErrCodeInvalidFrame, "frame is not valid JSON", nil)
+			return inboundEvent{}, Message{}, false
+		}
+		event, ok = inboundEvents[msg.Type]
+		if !ok {
+			event = inboundEvents[eventSendMessage]
+		}
+		return event, msg, true
+	}
+
+	if c.Protocol == SubprotocolV1MsgPack {
+		var buf bytes.Buffer
+		if _, err := msgp.UnmarshalAsJSON(&buf, data); err != nil {
+			c.sendError(ErrCodeInvalidFrame, "frame is not valid MessagePack", nil)
+			return inboundEvent{}, Message{}, false
+		}
+		data = buf.Bytes()
+	}
+
+	var env Envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		c.sendError(ErrCodeInvalidFrame, "frame is not a valid envelope", nil)
+		return inboundEvent{}, Message{}, false
+	}
+	ref := &Message{RequestID: env.ID}
+	if env.V != ProtocolVersion {
+		c.sendError(ErrCodeUnsupportedVersion, fmt.Sprintf("protocol version %d is not supported", env.V), ref)
+		return inboundEvent{}, Message{}, false
+	}
+	event, ok = inboundEvents[env.Type]
+	if !ok {
+		c.sendError(ErrCodeUnknownType, fmt.Sprintf("unknown frame type %q", env.Type), ref)
+		return inboundEvent{}, Message{}, false
+	}
+	if len(env.Payload) > 0 {
+		if err := json.Unmarshal(env.Payload, &msg); err != nil {
+			c.sendError(ErrCodeInvalidFrame, "payload does not match "+env.Type, ref)
+			return inboundEvent{}, Message{}, false
+		}
+	}
+	if env.Type != eventSendMessage {
+		msg.Type = env.Type
+	} else if _, isEvent := inboundEvents[msg.Type]; isEvent {
+		c.sendError(ErrCodeInvalidFrame, fmt.Sprintf("%q is not a message content type", msg.Type), ref)
+		return inboundEvent{}, Message{}, false
+	}
+	msg.RequestID = env.ID
+	return event, msg, true
+}
+
+// encodeFrame converts an outgoing frame from the JSON shared between hub
+// instances into the wire format of the given protocol.
+func encodeFrame(protocol string, data []byte) []byte {
+	if protocol == "" {
+		return data
+	}
+
+	var head struct {
+		Type      string `json:"type"`
+		RequestID string `json:"request_id"`
+	}
+	if err := json.Unmarshal(data, &head); err != nil {
+		log.Printf("Cannot wrap malformed outgoing frame: %v", err)
+		return data
+	}
+	frameType := head.Type
+	if !serverEvents[frameType] {
+		frameType = "message"
+	}
+
+	var out []byte
+	var err error
+	if protocol == SubprotocolV1MsgPack {
+		out, err = marshalMsgPackEnvelope(frameType, head.RequestID, data)
+	} else {
+		out, err = json.Marshal(Envelope{
+			V:       ProtocolVersion,
+			Type:    frameType,
+			ID:      head.RequestID,
+			Payload: data,
+		})
+	}
+	if err != nil {
+		log.Printf("Failed to encode %s frame for %s: %v", frameType, protocol, err)
+		return data
+	}
+	return out
+}
+
+// marshalMsgPackEnvelope transcodes a JSON payload into a MessagePack
+// envelope. Numbers are kept exact so IDs survive the round trip.
+func marshalMsgPackEnvelope(frameType, id string, data []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var payload any
+	if err := dec.Decode(&payload); err != nil {
+		return nil, err
+	}
+
+	env := map[string]any{
+		"v":       ProtocolVersion,
+		"type":    frameType,
+		"payload": payload,
+	}
+	if id != "" {
+		env["id"] = id
+	}
+	return msgp.AppendIntf(nil, env)
+}
+
+// wireMessageType is the WebSocket frame type used for a protocol.
+func wireMessageType(protocol string) int {
+	if protocol == SubprotocolV1MsgPack {
+		return websocket.BinaryMessage
+	}
+	return websocket.TextMessage
+}
+
+func (c *Client) encode(data []byte) []byte {
+	return encodeFrame(c.Protocol, data)
+}
+
+// outboundFrame is a server frame in the JSON shared between instances.
+// Fan-outs hand the same frame to every recipient so that it is encoded
+// at most once per protocol rather than once per session.
+type outboundFrame struct {
+	data    []byte
+	encoded map[string][]byte
+
+	// key caches coalesceKey once keyed is set.
+	key   string
+	keyed bool
+}
+
+func newOutboundFrame(data []byte) *outboundFrame {
+	return &outboundFrame{data: data}
+}
+
+// encode returns the frame in the wire format of the protocol. It is not
+// safe for concurrent use.
+func (f *outboundFrame) encode(protocol string) []byte {
+	if out, ok := f.encoded[protocol]; ok {
+		return out
+	}
+	if f.encoded == nil {
+		f.encoded = make(map[string][]byte, 1)
+	}
+	out := encodeFrame(protocol, f.data)
+	f.encoded[protocol] = out
+	return out
+}
+
+func noValidation(*Message) string { return "" }
+
+func validateSendMessage(msg *Message) string {
+	if msg.Type == "" {
+		msg.Type = "text"
+	}
+	if msg.Type == MessageTypeSystem {
+		return "system messages can only be sent by the server"
+	}
+	return ""
+}
+
+func validateEdit(msg *Message) string {
+	if msg.ID == 0 || strings.TrimSpace(msg.Content) == "" {
+		return "edit_message requires id and content"
+	}
+	return ""
+}
+
+func validateReadPosition(msg *Message) string {
+	if msg.LastReadMessageID <= 0 {
+		return "mark_as_read requires last_read_message_id"
+	}
+	return ""
+}
+
+func validateDeliveredPosition(msg *Message) string {
+	if msg.LastDeliveredMessageID <= 0 {
+		return "mark_as_delivered requires last_delivered_message_id"
+	}
+	return ""
+}
+
+func requireMessageID(msg *Message) string {
+	if msg.ID == 0 {
+		return msg.Type + " requires id"
+	}
+	return ""
+}
+
+func validateReaction(msg *Message) string {
+	if msg.ID == 0 || !validEmoji(msg.Emoji) {
+		return msg.Type + " requires id and an emoji of at most 32 characters"
+	}
+	return ""
+}
@@ -0,0 +1,152 @@
+package chat
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/tinylib/msgp/msgp"
+)
+
+func TestDecodeFrame(t *testing.T) {
+	tests := []struct {
+		name     string
+		protocol string
+		frame    string
+		wantType string
+		wantCode string
+	}{
+		{
+			name:     "legacy message",
+			frame:    `{"type":"text","conversation_id":1,"content":"hi"}`,
+			wantType: "text",
+		},
+		{
+			name:     "legacy event",
+			frame:    `{"type":"mark_as_read","conversation_id":1,"last_read_message_id":3}`,
+			wantType: "mark_as_read",
+		},
+		{
+			name:     "envelope message",
+			protocol: SubprotocolV1,
+			frame:    `{"v":1,"type":"send_message","id":"r1","payload":{"type":"file","conversation_id":1}}`,
+			wantType: "file",
+		},
+		{
+			name:     "envelope event",
+			protocol: SubprotocolV1,
+			frame:    `{"v":1,"type":"typing_start","id":"r2","payload":{"conversation_id":1}}`,
+			wantType: "typing_start",
+		},
+		{
+			name:     "unsupported version",
+			protocol: SubprotocolV1,
+			frame:    `{"v":2,"type":"typing_start","id":"r3"}`,
+			wantCode: ErrCodeUnsupportedVersion,
+		},
+		{
+			name:     "unknown type",
+			protocol: SubprotocolV1,
+			frame:    `{"v":1,"type":"text","id":"r4"}`,
+			wantCode: ErrCodeUnknownType,
+		},
+		{
+			name:     "event as content type",
+			protocol: SubprotocolV1,
+			frame:    `{"v":1,"type":"send_message","id":"r5","payload":{"type":"edit_message"}}`,
+			wantCode: ErrCodeInvalidFrame,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestClient(nil, "u1", "web")
+			c.Protocol = tt.protocol
+
+			_, msg, ok := c.decodeFrame([]byte(tt.frame))
+			if tt.wantCode == "" {
+				if !ok || msg.Type != tt.wantType {
+					t.Fatalf("decodeFrame() = %q, %v, want %q", msg.Type, ok, tt.wantType)
+				}
+				return
+			}
+			if ok {
+				t.Fatalf("decodeFrame() accepted the frame, want %s", tt.wantCode)
+			}
+
+			var env Envelope
+			var frame ErrorFrame
+			if err := json.Unmarshal(<-c.Send, &env); err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal(env.Payload, &frame); err != nil {
+				t.Fatal(err)
+			}
+			if env.Type != "error" || frame.Code != tt.wantCode || env.ID == "" || env.ID != frame.RequestID {
+				t.Errorf("got %s frame %+v with id %q, want %s", env.Type, frame, env.ID, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestEncodeFrame(t *testing.T) {
+	legacy := []byte(`{"type":"text","content":"hi"}`)
+	if got := encodeFrame("", legacy); string(got) != string(legacy) {
+		t.Errorf("legacy frame changed: %s", got)
+	}
+
+	var env Envelope
+	if err := json.Unmarshal(encodeFrame(SubprotocolV1, legacy), &env); err != nil {
+		t.Fatal(err)
+	}
+	if env.V != ProtocolVersion || env.Type != "message" {
+		t.Errorf("chat message wrapped as v%d %q, want v%d message", env.V, env.Type, ProtocolVersion)
+	}
+
+	ack := []byte(`{"type":"ack","client_msg_id":"c1","request_id":"r1"}`)
+	if err := json.Unmarshal(encodeFrame(SubprotocolV1, ack), &env); err != nil {
+		t.Fatal(err)
+	}
+	if env.Type != "ack" || env.ID != "r1" {
+		t.Errorf("ack wrapped as %q with id %q", env.Type, env.ID)
+	}
+}
+
+func TestMsgPackFrames(t *testing.T) {
+	ack := []byte(`{"type":"ack","client_msg_id":"c1","message_id":9007199254740993,"request_id":"r1"}`)
+	out := encodeFrame(SubprotocolV1MsgPack, ack)
+
+	var buf bytes.Buffer
+	if _, err := msgp.UnmarshalAsJSON(&buf, out); err != nil {
+		t.Fatalf("outgoing frame is not MessagePack: %v", err)
+	}
+	var env Envelope
+	var frame AckFrame
+	if err := json.Unmarshal(buf.Bytes(), &env); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(env.Payload, &frame); err != nil {
+		t.Fatal(err)
+	}
+	if env.Type != "ack" || env.ID != "r1" || frame.MessageID != 9007199254740993 {
+		t.Errorf("got %s frame %+v with id %q", env.Type, frame, env.ID)
+	}
+
+	in, err := msgp.AppendIntf(nil, map[string]any{
+		"v":    ProtocolVersion,
+		"type": "mark_as_read",
+		"id":   "r2",
+		"payload": map[string]any{
+			"conversation_id":      int64(7),
+			"last_read_message_id": int64(42),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newTestClient(nil, "u1", "mobile")
+	c.Protocol = SubprotocolV1MsgPack
+	_, msg, ok := c.decodeFrame(in)
+	if !ok || msg.Type != "mark_as_read" || msg.ConversationID != 7 || msg.LastReadMessageID != 42 || msg.RequestID != "r2" {
+		t.Errorf("decodeFrame() = %+v, %v", msg, ok)
+	}
+}
@@ -0,0 +1,304 @@
+package chat
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Scopes a frame can be rate limited in.
+const (
+	RateScopeConnection   = "connection"
+	RateScopeUser         = "user"
+	RateScopeConversation = "conversation"
+)
+
+// rateLimitSweepInterval is how often idle buckets are dropped.
+const rateLimitSweepInterval = time.Minute
+
+// rateLimitStats counts rejected frames as "<scope>.<event>" and abusive
+// connections closed as "disconnected". It is served on /debug/vars of the
+// admin listener.
+var rateLimitStats = expvar.NewMap("chat_rate_limit")
+
+// rateLimit is a token bucket refilled at Rate tokens per second and
+// holding at most Burst.
+type rateLimit struct {
+	Rate  float64
+	Burst float64
+}
+
+// eventLimits are the limits of one inbound event type. The user limit
+// spans all of a user's sessions on this instance and all conversations;
+// the conversation limit applies to the user within a single conversation.
+type eventLimits struct {
+	User         rateLimit
+	Conversation rateLimit
+}
+
+var (
+	messageLimits = eventLimits{User: rateLimit{Rate: 10, Burst: 30}, Conversation: rateLimit{Rate: 5, Burst: 15}}
+	actionLimits  = eventLimits{User: rateLimit{Rate: 5, Burst: 20}, Conversation: rateLimit{Rate: 3, Burst: 10}}
+	receiptLimits = eventLimits{User: rateLimit{Rate: 20, Burst: 60}, Conversation: rateLimit{Rate: 5, Burst: 20}}
+	typingLimits  = eventLimits{User: rateLimit{Rate: 5, Burst: 10}, Conversation: rateLimit{Rate: 2, Burst: 5}}
+)
+
+// defaultEventLimits cover every inbound event type; WS_RATE_LIMITS
+// overrides them.
+var defaultEventLimits = map[string]eventLimits{
+	eventSendMessage:    messageLimits,
+	"edit_message":      actionLimits,
+	"pin_message":       actionLimits,
+	"unpin_message":     actionLimits,
+	"add_reaction":      actionLimits,
+	"remove_reaction":   actionLimits,
+	"mark_as_read":      receiptLimits,
+	"mark_as_delivered": receiptLimits,
+	"typing_start":      typingLimits,
+	"typing_stop":       typingLimits,
+}
+
+var (
+	// defaultConnectionLimit caps the raw frames read from one socket,
+	// whatever they contain, before they are even decoded.
+	defaultConnectionLimit = rateLimit{Rate: 20, Burst: 40}
+
+	// violationLimit is the budget of rejected frames a connection may
+	// accumulate: once it is spent the connection is closed.
+	violationLimit = rateLimit{Rate: 0.5, Burst: 10}
+)
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func (b *tokenBucket) refill(l rateLimit, now time.Time) {
+	if b.last.IsZero() {
+		b.tokens = l.Burst
+	} else {
+		b.tokens = min(l.Burst, b.tokens+now.Sub(b.last).Seconds()*l.Rate)
+	}
+	b.last = now
+}
+
+// allow takes a token if one is available.
+func (b *tokenBucket) allow(l rateLimit, now time.Time) bool {
+	b.refill(l, now)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// retryAfter is how long until the next token is available.
+func (b *tokenBucket) retryAfter(l rateLimit) time.Duration {
+	if b.tokens >= 1 || l.Rate <= 0 {
+		return 0
+	}
+	return time.Duration(math.Ceil((1 - b.tokens) / l.Rate * float64(time.Second)))
+}
+
+type rateKey struct {
+	event  string
+	userID string
+	// conversationID is 0 for the user-wide bucket.
+	conversationID int64
+}
+
+// rateLimiter holds the user and conversation buckets shared by all of a
+// user's sessions on this instance.
+type rateLimiter struct {
+	mu         sync.Mutex
+	limits     map[string]eventLimits
+	connection rateLimit
+	buckets    map[rateKey]*tokenBucket
+	lastSweep  time.Time
+}
+
+func newRateLimiter(limits map[string]eventLimits, connection rateLimit) *rateLimiter {
+	return &rateLimiter{
+		limits:     limits,
+		connection: connection,
+		buckets:    make(map[rateKey]*tokenBucket),
+		lastSweep:  time.Now(),
+	}
+}
+
+// parseRateLimits applies overrides of the form
+// "send_message.user=10/30,typing_start.conversation=1/4,connection=20/40",
+// each setting a rate per second and a burst.
+func parseRateLimits(overrides string) (map[string]eventLimits, rateLimit, error) {
+	limits := make(map[string]eventLimits, len(defaultEventLimits))
+	for event, l := range defaultEventLimits {
+		limits[event] = l
+	}
+	connection := defaultConnectionLimit
+
+	for _, entry := range strings.Split(overrides, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, rateLimit{}, fmt.Errorf("rate limit %q: missing '='", entry)
+		}
+		rateStr, burstStr, ok := strings.Cut(value, "/")
+		rate, err1 := strconv.ParseFloat(rateStr, 64)
+		burst, err2 := strconv.ParseFloat(burstStr, 64)
+		if !ok || err1 != nil || err2 != nil || rate <= 0 || burst < 1 {
+			return nil, rateLimit{}, fmt.Errorf("rate limit %q: want <rate>/<burst>", entry)
+		}
+		l := rateLimit{Rate: rate, Burst: burst}
+
+		if key == RateScopeConnection {
+			connection = l
+			continue
+		}
+		event, scope, _ := strings.Cut(key, ".")
+		current, known := limits[event]
+		if !known {
+			return nil, rateLimit{}, fmt.Errorf("rate limit %q: unknown event %q", entry, event)
+		}
+		switch scope {
+		case RateScopeUser:
+			current.User = l
+		case RateScopeConversation:
+			current.Conversation = l
+		default:
+			return nil, rateLimit{}, fmt.Errorf("rate limit %q: scope must be user or conversation", entry)
+		}
+		limits[event] = current
+	}
+	return limits, connection, nil
+}
+
+// allow takes a token from both the user and the conversation bucket of
+// the event, or from neither. When the frame is refused it returns the
+// exhausted scope and when to retry.
+func (l *rateLimiter) allow(event, userID string, conversationID int64, now time.Time) (string, time.Duration, bool) {
+	limits, ok := l.limits[event]
+	if !ok {
+		return "", 0, true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+
+	user := l.bucket(rateKey{event: event, userID: userID})
+	conv := l.bucket(rateKey{event: event, userID: userID, conversationID: conversationID})
+	user.refill(limits.User, now)
+	conv.refill(limits.Conversation, now)
+	if user.tokens < 1 {
+		return RateScopeUser, user.retryAfter(limits.User), false
+	}
+	if conv.tokens < 1 {
+		return RateScopeConversation, conv.retryAfter(limits.Conversation), false
+	}
+	user.tokens--
+	conv.tokens--
+	return "", 0, true
+}
+
+func (l *rateLimiter) bucket(key rateKey) *tokenBucket {
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{}
+		l.buckets[key] = b
+	}
+	return b
+}
+
+// sweep drops the buckets that refilled completely, which behave exactly
+// like new ones. The caller holds l.mu.
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < rateLimitSweepInterval {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		limits := l.limits[key.event]
+		limit := limits.User
+		if key.conversationID != 0 {
+			limit = limits.Conversation
+		}
+		b.refill(limit, now)
+		if b.tokens >= limit.Burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// EnableRateLimit turns on flood protection for inbound frames, with the
+// overrides described in parseRateLimits. It must be called before Run.
+func (h *Hub) EnableRateLimit(overrides string) error {
+	limits, connection, err := parseRateLimits(overrides)
+	if err != nil {
+		return err
+	}
+	h.limiter = newRateLimiter(limits, connection)
+	return nil
+}
+
+// eventName is the registry key of a decoded frame; message content types
+// all count as send_message.
+func eventName(msg Message) string {
+	if _, ok := inboundEvents[msg.Type]; ok {
+		return msg.Type
+	}
+	return eventSendMessage
+}
+
+// RateLimitFrame is the error frame of a refused frame. RetryAfterMs tells
+// the client when the limit allows the same frame again.
+type RateLimitFrame struct {
+	ErrorFrame
+	Scope        string `json:"scope"`
+	RetryAfterMs int64  `json:"retry_after_ms"`
+}
+
+// rateLimited tells the client its frame was dropped and counts it against
+// the connection's violation budget, closing the connection with a policy
+// violation once that is spent.
+func (c *Client) rateLimited(event, scope string, retryAfter time.Duration, msg *Message) {
+	rateLimitStats.Add(scope+"."+event, 1)
+
+	data, err := json.Marshal(RateLimitFrame{
+		ErrorFrame:   newErrorFrame(ErrCodeRateLimited, scope+" rate limit exceeded for "+event+", slow down", msg),
+		Scope:        scope,
+		RetryAfterMs: retryAfter.Milliseconds(),
+	})
+	if err == nil {
+		c.trySend(c.encode(data))
+	}
+
+	c.limitMu.Lock()
+	abusive := !c.violations.allow(violationLimit, time.Now())
+	c.limitMu.Unlock()
+	if !abusive {
+		return
+	}
+
+	// Frames still in flight keep failing until the socket is closed.
+	c.mu.Lock()
+	evicted := c.evicted
+	c.evicted = true
+	c.mu.Unlock()
+	if evicted {
+		return
+	}
+	rateLimitStats.Add("disconnected", 1)
+	log.Printf("Closing session %s of user %s: sustained rate limit violations", c.ID, c.UserID)
+	go c.closeWith(websocket.ClosePolicyViolation, "rate limit exceeded")
+}
@@ -0,0 +1,124 @@
+package chat
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestParseRateLimits(t *testing.T) {
+	limits, connection, err := parseRateLimits("send_message.user=1/2, typing_start.conversation=0.5/3,connection=50/100")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := limits[eventSendMessage].User; got != (rateLimit{Rate: 1, Burst: 2}) {
+		t.Errorf("send_message user limit = %+v", got)
+	}
+	if got := limits[eventSendMessage].Conversation; got != messageLimits.Conversation {
+		t.Errorf("send_message conversation limit changed to %+v", got)
+	}
+	if got := limits["typing_start"].Conversation; got != (rateLimit{Rate: 0.5, Burst: 3}) {
+		t.Errorf("typing_start conversation limit = %+v", got)
+	}
+	if connection != (rateLimit{Rate: 50, Burst: 100}) {
+		t.Errorf("connection limit = %+v", connection)
+	}
+	if defaultEventLimits[eventSendMessage] != messageLimits {
+		t.Error("overrides leaked into the defaults")
+	}
+
+	for _, bad := range []string{"send_message.user", "send_message.user=1", "send_message.user=0/5", "shout.user=1/1", "send_message.node=1/1"} {
+		if _, _, err := parseRateLimits(bad); err == nil {
+			t.Errorf("parseRateLimits(%q) accepted", bad)
+		}
+	}
+}
+
+func TestRateLimiterScopes(t *testing.T) {
+	l := newRateLimiter(map[string]eventLimits{
+		eventSendMessage: {User: rateLimit{Rate: 1, Burst: 3}, Conversation: rateLimit{Rate: 1, Burst: 2}},
+	}, defaultConnectionLimit)
+	now := time.Now()
+
+	for i := range 2 {
+		if _, _, ok := l.allow(eventSendMessage, "u1", 1, now); !ok {
+			t.Fatalf("frame %d refused within the burst", i)
+		}
+	}
+	scope, retryAfter, ok := l.allow(eventSendMessage, "u1", 1, now)
+	if ok || scope != RateScopeConversation || retryAfter != time.Second {
+		t.Fatalf("third frame in Conv 1: scope %q, retry %v, ok %v", scope, retryAfter, ok)
+	}
+
+	// The refused frame took no token from the user bucket.
+	if _, _, ok := l.allow(eventSendMessage, "u1", 2, now); !ok {
+		t.Fatal("first frame in Conv 2 refused")
+	}
+	if scope, _, ok := l.allow(eventSendMessage, "u1", 3, now); ok || scope != RateScopeUser {
+		t.Fatalf("user burst not enforced: scope %q, ok %v", scope, ok)
+	}
+	if _, _, ok := l.allow(eventSendMessage, "u2", 1, now); !ok {
+		t.Fatal("another user was limited")
+	}
+
+	if _, _, ok := l.allow(eventSendMessage, "u1", 1, now.Add(time.Second)); !ok {
+		t.Fatal("bucket did not refill")
+	}
+}
+
+func TestRateLimitedClosesOnce(t *testing.T) {
+	server, peer := newTestConn(t)
+	c := newTestClient(nil, "u1", "web")
+	c.Conn = server
+	c.Send = make(chan []byte, 64)
+
+	disconnected := func() int64 {
+		n, _ := rateLimitStats.Get("disconnected").(*expvar.Int)
+		if n == nil {
+			return 0
+		}
+		return n.Value()
+	}
+	before := disconnected()
+	for range 2 * int(violationLimit.Burst) {
+		c.rateLimited(eventSendMessage, RateScopeUser, time.Second, nil)
+	}
+	if n := disconnected() - before; n != 1 {
+		t.Errorf("connection closed %d times, want once", n)
+	}
+
+	peer.SetReadDeadline(time.Now().Add(time.Second))
+	_, _, err := peer.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.ClosePolicyViolation) {
+		t.Errorf("peer got %v, want close code %d", err, websocket.ClosePolicyViolation)
+	}
+}
+
+// newTestConn returns both ends of a live WebSocket connection.
+func newTestConn(t *testing.T) (server, client *websocket.Conn) {
+	t.Helper()
+	conns := make(chan *websocket.Conn, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conns <- conn
+	}))
+	t.Cleanup(srv.Close)
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	server = <-conns
+	t.Cleanup(func() { server.Close() })
+	return server, client
+}
@@ -0,0 +1,89 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"unicode/utf8"
+
+	"corechain-communication/internal/db"
+)
+
+// maxEmojiLength matches the message_reactions.emoji column.
+const maxEmojiLength = 32
+
+// ReactionEvent is broadcast to conversation members when a reaction is
+// added or removed. Reactions never trigger push notifications.
+type ReactionEvent struct {
+	Type           string `json:"type"`
+	MessageID      int64  `json:"message_id"`
+	ConversationID int64  `json:"conversation_id"`
+	UserID         string `json:"user_id"`
+	Emoji          string `json:"emoji"`
+}
+
+// ReactionSummary aggregates the reactions of one emoji on a message.
+type ReactionSummary struct {
+	Emoji       string `json:"emoji"`
+	Count       int64  `json:"count"`
+	ReactedByMe bool   `json:"reacted_by_me"`
+}
+
+func validEmoji(emoji string) bool {
+	n := utf8.RuneCountInString(emoji)
+	return n > 0 && n <= maxEmojiLength
+}
+
+// BroadcastReaction tells the connected members that msg.SenderID added or
+// removed msg.Emoji on message msg.ID.
+func (h *Hub) BroadcastReaction(ctx context.Context, msg Message) {
+	eventType := "reaction_added"
+	if msg.Type == "remove_reaction" {
+		eventType = "reaction_removed"
+	}
+	data, err := json.Marshal(ReactionEvent{
+		Type:           eventType,
+		MessageID:      msg.ID,
+		ConversationID: msg.ConversationID,
+		UserID:         msg.SenderID,
+		Emoji:          msg.Emoji,
+	})
+	if err != nil {
+		return
+	}
+
+	memberIDs, err := h.conversationMembers(ctx, msg.ConversationID)
+	if err != nil {
+		log.Printf("Failed to load participants for Conv %d: %v", msg.ConversationID, err)
+		return
+	}
+	frame := newOutboundFrame(data)
+	for _, memberID := range memberIDs {
+		h.deliverFrame(ctx, memberID, frame)
+	}
+}
+
+// loadReactions returns the reaction summaries of a batch of messages as
+// seen by userID, keyed by message ID.
+func loadReactions(ctx context.Context, q *db.Queries, userID string, messageIDs []int64) (map[int64][]ReactionSummary, error) {
+	reactions := make(map[int64][]ReactionSummary)
+	if len(messageIDs) == 0 {
+		return reactions, nil
+	}
+
+	rows, err := q.ListReactionSummaries(ctx, db.ListReactionSummariesParams{
+		UserID:     userID,
+		MessageIds: messageIDs,
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range rows {
+		reactions[r.MessageID] = append(reactions[r.MessageID], ReactionSummary{
+			Emoji:       r.Emoji,
+			Count:       r.Count,
+			ReactedByMe: r.ReactedByMe,
+		})
+	}
+	return reactions, nil
+}
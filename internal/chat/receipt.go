@@ -0,0 +1,301 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"corechain-communication/internal/client"
+	"corechain-communication/internal/db"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Aggregate delivery status of a message, as seen by its sender. Both
+// watermarks behind it are client-driven: a message is only delivered once
+// every recipient acknowledged it with mark_as_delivered (or mark_as_read).
+// Queueing it on a recipient's socket does not count, so clients must
+// acknowledge what they receive, live or replayed during catch-up.
+const (
+	MessageStatusSent      = "sent"
+	MessageStatusDelivered = "delivered"
+	MessageStatusRead      = "read"
+)
+
+var (
+	ErrReadBackwards         = errors.New("read position cannot move backwards")
+	ErrReadBeyondLatest      = errors.New("read position is beyond the latest message")
+	ErrDeliveredBeyondLatest = errors.New("delivered position is beyond the latest message")
+)
+
+// ReadReceipt tells the members of a conversation how far a user has read.
+// It is never sent to the notification topic.
+type ReadReceipt struct {
+	Type              string     `json:"type"`
+	ConversationID    int64      `json:"conversation_id"`
+	UserID            string     `json:"user_id"`
+	LastReadMessageID int64      `json:"last_read_message_id"`
+	ReadAt            *time.Time `json:"read_at,omitempty"`
+}
+
+func newReadReceipt(conversationID int64, userID string, lastReadID int64, readAt pgtype.Timestamptz) ReadReceipt {
+	receipt := ReadReceipt{
+		Type:              "read_receipt",
+		ConversationID:    conversationID,
+		UserID:            userID,
+		LastReadMessageID: lastReadID,
+	}
+	if readAt.Valid {
+		t := readAt.Time
+		receipt.ReadAt = &t
+	}
+	return receipt
+}
+
+// DeliveryReceipt tells senders that one of the user's devices confirmed,
+// with mark_as_delivered, that it received every message up to
+// LastDeliveredMessageID.
+type DeliveryReceipt struct {
+	Type                   string     `json:"type"`
+	ConversationID         int64      `json:"conversation_id"`
+	UserID                 string     `json:"user_id"`
+	LastDeliveredMessageID int64      `json:"last_delivered_message_id"`
+	DeliveredAt            *time.Time `json:"delivered_at,omitempty"`
+
+	// senderIDs are the users whose messages the receipt covers.
+	senderIDs []string
+}
+
+// MessageReader is one entry of a message's "seen by" list.
+type MessageReader struct {
+	UserID string     `json:"user_id"`
+	Name   string     `json:"name,omitempty"`
+	Avatar string     `json:"avatar,omitempty"`
+	ReadAt *time.Time `json:"read_at,omitempty"`
+}
+
+// MarkRead moves the user's read position forward to messageID. It returns
+// nil when the position is unchanged, e.g. on a Kafka redelivery.
+func MarkRead(ctx context.Context, q *db.Queries, userID string, conversationID, messageID int64) (*ReadReceipt, error) {
+	pos, err := q.GetReadPosition(ctx, db.GetReadPositionParams{
+		ConversationID: conversationID,
+		UserID:         userID,
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotMember
+	}
+	if err != nil {
+		return nil, err
+	}
+	if messageID > pos.LastMessageID.Int64 {
+		return nil, ErrReadBeyondLatest
+	}
+	if messageID < pos.LastReadMessageID.Int64 {
+		return nil, ErrReadBackwards
+	}
+
+	readAt, err := q.MarkMessageAsRead(ctx, db.MarkMessageAsReadParams{
+		ConversationID:    conversationID,
+		UserID:            userID,
+		LastReadMessageID: pgtype.Int8{Int64: messageID, Valid: true},
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	receipt := newReadReceipt(conversationID, userID, messageID, readAt)
+	return &receipt, nil
+}
+
+// MarkDelivered moves the user's delivered watermark forward to messageID.
+// Devices confirm independently and out of order, so an older position is
+// not an error; like an unchanged one it returns nil.
+func MarkDelivered(ctx context.Context, q *db.Queries, userID string, conversationID, messageID int64) (*DeliveryReceipt, error) {
+	pos, err := q.GetReadPosition(ctx, db.GetReadPositionParams{
+		ConversationID: conversationID,
+		UserID:         userID,
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotMember
+	}
+	if err != nil {
+		return nil, err
+	}
+	if messageID > pos.LastMessageID.Int64 {
+		return nil, ErrDeliveredBeyondLatest
+	}
+	if messageID <= pos.LastDeliveredMessageID.Int64 {
+		return nil, nil
+	}
+
+	deliveredAt, err := q.MarkMessageAsDelivered(ctx, db.MarkMessageAsDeliveredParams{
+		ConversationID:         conversationID,
+		UserID:                 userID,
+		LastDeliveredMessageID: pgtype.Int8{Int64: messageID, Valid: true},
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	senderIDs, err := q.ListMessageSendersBetween(ctx, db.ListMessageSendersBetweenParams{
+		ConversationID: conversationID,
+		AfterID:        pos.LastDeliveredMessageID.Int64,
+		UpToID:         messageID,
+		UserID:         userID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	receipt := DeliveryReceipt{
+		Type:                   "delivered",
+		ConversationID:         conversationID,
+		UserID:                 userID,
+		LastDeliveredMessageID: messageID,
+		senderIDs:              senderIDs,
+	}
+	if deliveredAt.Valid {
+		t := deliveredAt.Time
+		receipt.DeliveredAt = &t
+	}
+	return &receipt, nil
+}
+
+// BroadcastDelivered tells the senders of the newly delivered messages.
+func (h *Hub) BroadcastDelivered(ctx context.Context, receipt DeliveryReceipt) {
+	data, err := json.Marshal(receipt)
+	if err != nil {
+		return
+	}
+	frame := newOutboundFrame(data)
+	for _, senderID := range receipt.senderIDs {
+		h.deliverFrame(ctx, senderID, frame)
+	}
+}
+
+// messageStatus aggregates the watermarks of every participant but the
+// sender: read once all of them read it, delivered once all received it.
+func messageStatus(m db.Message, participants []db.ListParticipantsByConversationRow) string {
+	if m.Type.String == MessageTypeSystem || m.IsDeleted.Bool {
+		return ""
+	}
+	read, delivered := true, true
+	for _, p := range participants {
+		if p.UserID == m.SenderID {
+			continue
+		}
+		if p.LastReadMessageID.Int64 < m.ID {
+			read = false
+		}
+		if max(p.LastDeliveredMessageID.Int64, p.LastReadMessageID.Int64) < m.ID {
+			delivered = false
+		}
+	}
+	switch {
+	case read:
+		return MessageStatusRead
+	case delivered:
+		return MessageStatusDelivered
+	}
+	return MessageStatusSent
+}
+
+// attachStatuses sets the aggregate delivery status of each message.
+func (s *ChatService) attachStatuses(ctx context.Context, conversationID int64, msgs []MessageResponse) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+	participants, err := s.queries.ListParticipantsByConversation(ctx, conversationID)
+	if err != nil {
+		return err
+	}
+	for i := range msgs {
+		msgs[i].Status = messageStatus(msgs[i].Message, participants)
+	}
+	return nil
+}
+
+// BroadcastReadReceipt delivers the receipt to every member, the reader
+// included so their other devices clear the unread badge too.
+func (h *Hub) BroadcastReadReceipt(ctx context.Context, receipt ReadReceipt) {
+	memberIDs, err := h.conversationMembers(ctx, receipt.ConversationID)
+	if err != nil {
+		log.Printf("Failed to load participants for Conv %d: %v", receipt.ConversationID, err)
+		return
+	}
+
+	data, err := json.Marshal(receipt)
+	if err != nil {
+		return
+	}
+	frame := newOutboundFrame(data)
+	for _, memberID := range memberIDs {
+		h.deliverFrame(ctx, memberID, frame)
+	}
+}
+
+// GetSeenBy lists the members who have read a message, most recent first.
+func (s *ChatService) GetSeenBy(ctx context.Context, userID string, conversationID, messageID int64) ([]MessageReader, error) {
+	ok, err := s.isMember(ctx, conversationID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrNotMember
+	}
+
+	msg, err := s.queries.GetMessageByID(ctx, db.GetMessageByIDParams{
+		ID:             messageID,
+		ConversationID: conversationID,
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.queries.ListMessageReaders(ctx, db.ListMessageReadersParams{
+		ConversationID: conversationID,
+		MessageID:      messageID,
+		SenderID:       msg.SenderID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	userIDs := make([]string, len(rows))
+	for i, r := range rows {
+		userIDs[i] = r.UserID
+	}
+	userMap, err := s.userClient.EnrichUsers(ctx, userIDs)
+	if err != nil {
+		log.Printf("Warning: failed to enrich some users: %v", err)
+	}
+	if userMap == nil {
+		userMap = make(map[string]client.UserInfo)
+	}
+
+	readers := make([]MessageReader, len(rows))
+	for i, r := range rows {
+		readers[i] = MessageReader{
+			UserID: r.UserID,
+			Name:   userMap[r.UserID].Name,
+			Avatar: userMap[r.UserID].Avatar,
+		}
+		if r.LastReadAt.Valid {
+			t := r.LastReadAt.Time
+			readers[i].ReadAt = &t
+		}
+	}
+	return readers, nil
+}
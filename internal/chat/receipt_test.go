@@ -0,0 +1,64 @@
+package chat
+
+import (
+	"testing"
+
+	"corechain-communication/internal/db"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+func TestMessageStatus(t *testing.T) {
+	participant := func(userID string, read, delivered int64) db.ListParticipantsByConversationRow {
+		return db.ListParticipantsByConversationRow{
+			UserID:                 userID,
+			LastReadMessageID:      pgtype.Int8{Int64: read, Valid: read > 0},
+			LastDeliveredMessageID: pgtype.Int8{Int64: delivered, Valid: delivered > 0},
+		}
+	}
+	msg := db.Message{ID: 10, SenderID: "alice"}
+
+	tests := []struct {
+		name         string
+		participants []db.ListParticipantsByConversationRow
+		want         string
+	}{
+		{
+			name:         "not received",
+			participants: []db.ListParticipantsByConversationRow{participant("alice", 0, 0), participant("bob", 0, 9)},
+			want:         MessageStatusSent,
+		},
+		{
+			name:         "received by some",
+			participants: []db.ListParticipantsByConversationRow{participant("bob", 0, 10), participant("carol", 0, 0)},
+			want:         MessageStatusSent,
+		},
+		{
+			name:         "received by all",
+			participants: []db.ListParticipantsByConversationRow{participant("bob", 0, 12), participant("carol", 0, 10)},
+			want:         MessageStatusDelivered,
+		},
+		{
+			name:         "read implies delivered",
+			participants: []db.ListParticipantsByConversationRow{participant("bob", 10, 0), participant("carol", 0, 11)},
+			want:         MessageStatusDelivered,
+		},
+		{
+			name:         "read by all",
+			participants: []db.ListParticipantsByConversationRow{participant("alice", 0, 0), participant("bob", 11, 11), participant("carol", 10, 10)},
+			want:         MessageStatusRead,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := messageStatus(msg, tt.participants); got != tt.want {
+				t.Errorf("messageStatus() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	system := db.Message{ID: 10, SenderID: "alice", Type: pgtype.Text{String: MessageTypeSystem, Valid: true}}
+	if got := messageStatus(system, nil); got != "" {
+		t.Errorf("system message status = %q, want none", got)
+	}
+}
@@ -0,0 +1,77 @@
+package chat
+
+import (
+	"context"
+	"log"
+
+	"corechain-communication/internal/db"
+)
+
+// quoteMaxRunes bounds the parent content carried inside every reply.
+const quoteMaxRunes = 100
+
+// QuotedMessage is the compact snapshot of the parent sent along with a
+// reply, enough for clients to render the quote without fetching it.
+type QuotedMessage struct {
+	ID        int64  `json:"id"`
+	SenderID  string `json:"sender_id"`
+	Type      string `json:"type"`
+	Content   string `json:"content,omitempty"`
+	FileName  string `json:"file_name,omitempty"`
+	IsDeleted bool   `json:"is_deleted,omitempty"`
+}
+
+func NewQuotedMessage(m db.Message) *QuotedMessage {
+	content := []rune(m.Content.String)
+	if len(content) > quoteMaxRunes {
+		content = append(content[:quoteMaxRunes], '…')
+	}
+	return &QuotedMessage{
+		ID:        m.ID,
+		SenderID:  m.SenderID,
+		Type:      m.Type.String,
+		Content:   string(content),
+		FileName:  m.FileName.String,
+		IsDeleted: m.IsDeleted.Bool,
+	}
+}
+
+// loadQuotes fetches the parents of a batch of replies in one query.
+func loadQuotes(ctx context.Context, q *db.Queries, parentIDs []int64) (map[int64]*QuotedMessage, error) {
+	quotes := make(map[int64]*QuotedMessage)
+	if len(parentIDs) == 0 {
+		return quotes, nil
+	}
+
+	parents, err := q.ListMessagesByIDs(ctx, parentIDs)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range parents {
+		quotes[p.ID] = NewQuotedMessage(p)
+	}
+	return quotes, nil
+}
+
+// messagesFromDB converts rows for sending and attaches the quote of the
+// message each reply points to.
+func (h *Hub) messagesFromDB(ctx context.Context, rows []db.Message) []Message {
+	var parentIDs []int64
+	for _, m := range rows {
+		if m.ReplyToID.Valid {
+			parentIDs = append(parentIDs, m.ReplyToID.Int64)
+		}
+	}
+
+	quotes, err := loadQuotes(ctx, h.q, parentIDs)
+	if err != nil {
+		log.Printf("Failed to load quoted messages: %v", err)
+	}
+
+	msgs := make([]Message, len(rows))
+	for i, m := range rows {
+		msgs[i] = newMessageFromDB(m)
+		msgs[i].ReplyTo = quotes[m.ReplyToID.Int64]
+	}
+	return msgs
+}
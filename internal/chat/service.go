@@ -2,12 +2,20 @@ package chat
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
+	"slices"
+	"strings"
+	"time"
 
 	"corechain-communication/internal/client"
+	"corechain-communication/internal/config"
 	"corechain-communication/internal/db"
 	"corechain-communication/internal/storage"
 
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
@@ -26,6 +34,7 @@ type ConversationDetail struct {
 	IsGroup               bool              `json:"is_group"`
 	Members               []MemberDetail    `json:"members"`
 	Messages              []MessageResponse `json:"messages"`
+	PinnedMessages        []PinnedMessage   `json:"pinned_messages"`
 	LastMessageID         int64             `json:"last_message_id"`
 	LastMessageAt         pgtype.Timestamp  `json:"last_message_at"`
 	LastMessageContent    string            `json:"last_message_content"`
@@ -33,6 +42,7 @@ type ConversationDetail struct {
 	LastMessageSenderName string            `json:"last_message_sender_name"`
 	LastMessageType       string            `json:"last_message_type"`
 	LastMessageFileName   string            `json:"last_message_file_name,omitempty"`
+	LastMessageIsDeleted  bool              `json:"last_message_is_deleted"`
 	CreatedAt             pgtype.Timestamp  `json:"created_at"`
 	UpdatedAt             pgtype.Timestamp  `json:"updated_at"`
 }
@@ -49,15 +59,97 @@ type ConversationSummary struct {
 	LastMessageSenderName string           `json:"last_message_sender_name"`
 	LastMessageType       string           `json:"last_message_type"`
 	LastMessageFileName   string           `json:"last_message_file_name,omitempty"`
+	LastMessageIsDeleted  bool             `json:"last_message_is_deleted"`
 	LastReadMessageID     int64            `json:"last_read_message_id"`
 	UnreadCount           int64            `json:"unread_count"`
+	UnreadMentionCount    int64            `json:"unread_mention_count"`
+
+	IsMuted    bool       `json:"is_muted"`
+	MutedUntil *time.Time `json:"muted_until,omitempty"`
+	IsArchived bool       `json:"is_archived"`
+	IsPinned   bool       `json:"is_pinned"`
+	PinnedAt   *time.Time `json:"pinned_at,omitempty"`
+}
+
+const (
+	FolderInbox    = "inbox"
+	FolderArchived = "archived"
+)
+
+type UserPresence struct {
+	UserID     string     `json:"user_id"`
+	Online     bool       `json:"online"`
+	LastSeenAt *time.Time `json:"last_seen_at,omitempty"`
 }
 
 type MessageResponse struct {
 	db.Message
-	FileURL string `json:"file_url"`
+	FileURL    string            `json:"file_url"`
+	Edited     bool              `json:"edited"`
+	ReplyTo    *QuotedMessage    `json:"reply_to,omitempty"`
+	ReplyCount int64             `json:"reply_count"`
+	Reactions  []ReactionSummary `json:"reactions"`
+	// Status is one of the MessageStatus values, built from the receipts
+	// recipients sent; it stays "sent" until their clients confirm.
+	Status string `json:"status,omitempty"`
+}
+
+type PinnedMessage struct {
+	Message      MessageResponse `json:"message"`
+	SenderName   string          `json:"sender_name"`
+	SenderAvatar string          `json:"sender_avatar,omitempty"`
+	PinnedBy     string          `json:"pinned_by"`
+	PinnedByName string          `json:"pinned_by_name"`
+	PinnedAt     time.Time       `json:"pinned_at"`
+}
+
+type SearchFilter struct {
+	Query          string
+	ConversationID int64
+	SenderID       string
+	Type           string
+	From           *time.Time
+	To             *time.Time
+	BeforeID       int64
+	Limit          int32
 }
 
+// SearchResult is one page of search hits, newest first. Snippets are the
+// raw message text with matches wrapped in <mark> tags, so clients must
+// escape everything else before rendering them as HTML.
+type SearchResult struct {
+	Results    []db.SearchMessagesRow `json:"results"`
+	NextCursor int64                  `json:"next_cursor,omitempty"`
+}
+
+type Thread struct {
+	Parent  MessageResponse   `json:"parent"`
+	Replies []MessageResponse `json:"replies"`
+}
+
+const (
+	DeleteScopeMe       = "me"
+	DeleteScopeEveryone = "everyone"
+
+	defaultRecallWindow = 24 * time.Hour
+
+	// fileCleanupDelay keeps a recalled file in storage until the presigned
+	// URLs already handed out for it have expired.
+	fileCleanupDelay = time.Hour
+
+	maxForwardMessages = 20
+	maxForwardTargets  = 20
+)
+
+var (
+	ErrNotMember        = errors.New("not a member of this conversation")
+	ErrMessageNotFound  = errors.New("message not found")
+	ErrEmptyContent     = errors.New("content must not be empty")
+	ErrInvalidScope     = errors.New("scope must be \"me\" or \"everyone\"")
+	ErrRecallNotAllowed = errors.New("message can no longer be recalled")
+	ErrTooManyForwards  = fmt.Errorf("at most %d messages to %d conversations per forward", maxForwardMessages, maxForwardTargets)
+)
+
 type ChatService struct {
 	queries    *db.Queries
 	pool       *pgxpool.Pool
@@ -121,16 +213,19 @@ func (s *ChatService) GetOrCreatePrivateConversation(ctx context.Context, userID
 		return 0, err
 	}
 
-	// TODO: Sync Redis cache here db.CacheParticipants
+	s.cacheParticipants(ctx, newConv.ID)
 
 	return newConv.ID, nil
 }
 
-func (s *ChatService) ListConversations(ctx context.Context, userID string, limit, offset int32) ([]ConversationSummary, error) {
+// ListConversations returns one page of the inbox or archived folder, with
+// pinned conversations first.
+func (s *ChatService) ListConversations(ctx context.Context, userID, folder string, limit, offset int32) ([]ConversationSummary, error) {
 	rows, err := s.queries.ListConversationsByUser(ctx, db.ListConversationsByUserParams{
-		SenderID: userID,
-		Limit:    limit,
-		Offset:   offset,
+		SenderID:   userID,
+		Limit:      limit,
+		Offset:     offset,
+		IsArchived: folder == FolderArchived,
 	})
 	if err != nil {
 		return nil, err
@@ -198,6 +293,7 @@ func (s *ChatService) ListConversations(ctx context.Context, userID string, limi
 			lastMessageSenderName = u.Name
 		}
 
+		prefs := newConversationPreferences(r.ID, r.MutedUntil, r.IsArchived, r.PinnedAt)
 		result = append(result, ConversationSummary{
 			ID:                    r.ID,
 			Name:                  name,
@@ -211,17 +307,25 @@ func (s *ChatService) ListConversations(ctx context.Context, userID string, limi
 			LastReadMessageID:     r.LastReadMessageID.Int64,
 			LastMessageType:       r.LastMessageType.String,
 			LastMessageFileName:   r.LastMessageFileName.String,
+			LastMessageIsDeleted:  r.LastMessageIsDeleted.Bool,
 			UnreadCount:           r.UnreadCount,
+			UnreadMentionCount:    r.UnreadMentionCount,
+			IsMuted:               prefs.IsMuted,
+			MutedUntil:            prefs.MutedUntil,
+			IsArchived:            prefs.IsArchived,
+			IsPinned:              prefs.IsPinned,
+			PinnedAt:              prefs.PinnedAt,
 		})
 	}
 
 	return result, nil
 }
 
-func (s *ChatService) GetMessages(ctx context.Context, convID int64, limit int32, beforeID int64) ([]MessageResponse, error) {
+func (s *ChatService) GetMessages(ctx context.Context, userID string, convID int64, limit int32, beforeID int64) ([]MessageResponse, error) {
 	dbMessages, err := s.queries.GetMessagesByConversation(ctx, db.GetMessagesByConversationParams{
 		ConversationID: convID,
 		BeforeID:       beforeID,
+		UserID:         userID,
 		LimitCount:     limit,
 	})
 	if err != nil {
@@ -232,6 +336,7 @@ func (s *ChatService) GetMessages(ctx context.Context, convID int64, limit int32
 	for i, m := range dbMessages {
 		res := MessageResponse{
 			Message: m,
+			Edited:  m.EditedAt.Valid,
 		}
 
 		if m.Type.String == "file" && m.FilePath.String != "" {
@@ -245,6 +350,16 @@ func (s *ChatService) GetMessages(ctx context.Context, convID int64, limit int32
 		finalMessages[i] = res
 	}
 
+	if err := s.attachThreads(ctx, finalMessages); err != nil {
+		log.Printf("Error loading thread info for Conv %d: %v", convID, err)
+	}
+	if err := s.attachReactions(ctx, userID, finalMessages); err != nil {
+		log.Printf("Error loading reactions for Conv %d: %v", convID, err)
+	}
+	if err := s.attachStatuses(ctx, convID, finalMessages); err != nil {
+		log.Printf("Error loading delivery status for Conv %d: %v", convID, err)
+	}
+
 	return finalMessages, nil
 }
 
@@ -286,12 +401,12 @@ func (s *ChatService) GetConversation(ctx context.Context, conversationID int64)
 	name := conv.Name.String
 	avatar := conv.Avatar.String
 
-	if !conv.IsGroup.Bool {
-		var currentUserID string
-		if v := ctx.Value("user_id"); v != nil {
-			currentUserID, _ = v.(string)
-		}
+	var currentUserID string
+	if v := ctx.Value("user_id"); v != nil {
+		currentUserID, _ = v.(string)
+	}
 
+	if !conv.IsGroup.Bool {
 		if currentUserID != "" {
 			for _, p := range participants {
 				if p.UserID != currentUserID {
@@ -308,6 +423,7 @@ func (s *ChatService) GetConversation(ctx context.Context, conversationID int64)
 	dbMessages, err := s.queries.GetMessagesByConversation(ctx, db.GetMessagesByConversationParams{
 		ConversationID: conversationID,
 		BeforeID:       0,
+		UserID:         currentUserID,
 		LimitCount:     20,
 	})
 	if err != nil {
@@ -319,6 +435,7 @@ func (s *ChatService) GetConversation(ctx context.Context, conversationID int64)
 	for i, msg := range dbMessages {
 		res := MessageResponse{
 			Message: msg,
+			Edited:  msg.EditedAt.Valid,
 		}
 		if msg.Type.String == "file" && msg.FilePath.String != "" {
 			signedURL, err := storage.GetPresignedURL(msg.FilePath.String)
@@ -332,6 +449,18 @@ func (s *ChatService) GetConversation(ctx context.Context, conversationID int64)
 		finalMessages[i] = res
 	}
 
+	if err := s.attachThreads(ctx, finalMessages); err != nil {
+		log.Printf("Error loading thread info for Conv %d: %v", conversationID, err)
+	}
+	if err := s.attachReactions(ctx, currentUserID, finalMessages); err != nil {
+		log.Printf("Error loading reactions for Conv %d: %v", conversationID, err)
+	}
+
+	pinned, err := s.listPinned(ctx, currentUserID, conversationID)
+	if err != nil {
+		log.Printf("Error loading pinned messages for Conv %d: %v", conversationID, err)
+	}
+
 	lastMessageSenderName := ""
 	if u, ok := userMap[conv.LastMessageSenderID.String]; ok {
 		lastMessageSenderName = u.Name
@@ -344,6 +473,7 @@ func (s *ChatService) GetConversation(ctx context.Context, conversationID int64)
 		IsGroup:               conv.IsGroup.Bool,
 		Members:               members,
 		Messages:              finalMessages,
+		PinnedMessages:        pinned,
 		LastMessageID:         conv.LastMessageID.Int64,
 		LastMessageAt:         conv.LastMessageAt,
 		LastMessageContent:    conv.LastMessageContent.String,
@@ -351,6 +481,7 @@ func (s *ChatService) GetConversation(ctx context.Context, conversationID int64)
 		LastMessageSenderName: lastMessageSenderName,
 		LastMessageType:       conv.LastMessageType.String,
 		LastMessageFileName:   conv.LastMessageFileName.String,
+		LastMessageIsDeleted:  conv.LastMessageIsDeleted.Bool,
 		CreatedAt:             conv.CreatedAt,
 		UpdatedAt:             conv.UpdatedAt,
 	}, nil
@@ -358,3 +489,542 @@ func (s *ChatService) GetConversation(ctx context.Context, conversationID int64)
 func (s *ChatService) GetTotalUnreadCount(ctx context.Context, userID string) (int64, error) {
 	return s.queries.GetTotalUnreadCount(ctx, userID)
 }
+
+// GetPresence returns the status of the requested users who share a
+// conversation with userID; the others are left out so presence cannot be
+// probed for arbitrary accounts.
+func (s *ChatService) GetPresence(ctx context.Context, userID string, requested []string) ([]UserPresence, error) {
+	partnerIDs, err := s.queries.ListConversationPartnerIDs(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	userIDs := make([]string, 0, len(requested))
+	for _, id := range requested {
+		if slices.Contains(partnerIDs, id) && !slices.Contains(userIDs, id) {
+			userIDs = append(userIDs, id)
+		}
+	}
+
+	online, lastSeen, err := db.GetUsersPresence(ctx, userIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	// Redis only knows users seen since it was last flushed; fall back to
+	// the persisted last-seen for the rest.
+	var missing []string
+	for _, id := range userIDs {
+		if _, ok := lastSeen[id]; !ok && !online[id] {
+			missing = append(missing, id)
+		}
+	}
+	if len(missing) > 0 {
+		rows, err := s.queries.ListUsersLastSeen(ctx, missing)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range rows {
+			lastSeen[r.UserID] = r.LastSeenAt.Time
+		}
+	}
+
+	result := make([]UserPresence, 0, len(userIDs))
+	for _, id := range userIDs {
+		p := UserPresence{UserID: id, Online: online[id]}
+		if t, ok := lastSeen[id]; ok {
+			p.LastSeenAt = &t
+		}
+		result = append(result, p)
+	}
+	return result, nil
+}
+
+// isMember checks the caller belongs to the conversation.
+func (s *ChatService) isMember(ctx context.Context, conversationID int64, userID string) (bool, error) {
+	participants, err := s.queries.ListParticipantsByConversation(ctx, conversationID)
+	if err != nil {
+		return false, err
+	}
+	return slices.ContainsFunc(participants, func(p db.ListParticipantsByConversationRow) bool {
+		return p.UserID == userID
+	}), nil
+}
+
+// EditMessage replaces the content of one of the caller's own messages and
+// keeps the previous version in the edit history. Members who left can no
+// longer edit what they sent.
+func (s *ChatService) EditMessage(ctx context.Context, userID string, conversationID, messageID int64, content string) (db.Message, error) {
+	if strings.TrimSpace(content) == "" {
+		return db.Message{}, ErrEmptyContent
+	}
+	ok, err := s.isMember(ctx, conversationID, userID)
+	if err != nil {
+		return db.Message{}, err
+	}
+	if !ok {
+		return db.Message{}, ErrNotMember
+	}
+
+	msg, err := s.queries.EditMessage(ctx, db.EditMessageParams{
+		ID:             messageID,
+		ConversationID: conversationID,
+		SenderID:       userID,
+		Content:        pgtype.Text{String: content, Valid: true},
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return db.Message{}, ErrMessageNotFound
+	}
+	return msg, err
+}
+
+func (s *ChatService) ListMessageEdits(ctx context.Context, userID string, conversationID, messageID int64) ([]db.MessageEdit, error) {
+	ok, err := s.isMember(ctx, conversationID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrNotMember
+	}
+
+	edits, err := s.queries.ListMessageEdits(ctx, db.ListMessageEditsParams{
+		MessageID:      messageID,
+		ConversationID: conversationID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if edits == nil {
+		edits = []db.MessageEdit{}
+	}
+	return edits, nil
+}
+
+func recallWindow() time.Duration {
+	if m := config.Get().MessageRecallWindowMinutes; m > 0 {
+		return time.Duration(m) * time.Minute
+	}
+	return defaultRecallWindow
+}
+
+// uploadedBy reports whether the message's file was uploaded by its sender.
+// Only then does recalling the message clean the object up: a file_path
+// recorded before uploads were tracked, or shared by a forwarded copy, is
+// never deleted.
+func (s *ChatService) uploadedBy(ctx context.Context, msg db.Message) (bool, error) {
+	if msg.FilePath.String == "" || msg.ForwardedFrom.Valid {
+		return false, nil
+	}
+	owner, err := s.queries.GetUploadOwner(ctx, msg.FilePath.String)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return owner == msg.SenderID, nil
+}
+
+// DeleteMessage hides a message from the caller's own history (scope "me")
+// or recalls it for every member (scope "everyone"). Senders may recall
+// within the recall window, group admins at any time. A recalled message
+// keeps its row but loses its content, edit history and file; the file
+// itself is removed from storage later by the cleanup worker.
+func (s *ChatService) DeleteMessage(ctx context.Context, userID string, conversationID, messageID int64, scope string) (db.Message, error) {
+	if scope != DeleteScopeMe && scope != DeleteScopeEveryone {
+		return db.Message{}, ErrInvalidScope
+	}
+
+	participants, err := s.queries.ListParticipantsByConversation(ctx, conversationID)
+	if err != nil {
+		return db.Message{}, err
+	}
+	idx := slices.IndexFunc(participants, func(p db.ListParticipantsByConversationRow) bool {
+		return p.UserID == userID
+	})
+	if idx < 0 {
+		return db.Message{}, ErrNotMember
+	}
+
+	msg, err := s.queries.GetMessageByID(ctx, db.GetMessageByIDParams{
+		ID:             messageID,
+		ConversationID: conversationID,
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return db.Message{}, ErrMessageNotFound
+	}
+	if err != nil {
+		return db.Message{}, err
+	}
+
+	if scope == DeleteScopeMe {
+		err := s.queries.HideMessage(ctx, db.HideMessageParams{
+			UserID:    userID,
+			MessageID: messageID,
+		})
+		return msg, err
+	}
+
+	if msg.IsDeleted.Bool {
+		return db.Message{}, ErrMessageNotFound
+	}
+	// System messages record what happened and cannot be taken back.
+	if msg.Type.String == MessageTypeSystem {
+		return db.Message{}, ErrRecallNotAllowed
+	}
+	isAdmin := participants[idx].Role.String == RoleAdmin
+	withinWindow := msg.SenderID == userID && time.Since(msg.CreatedAt.Time) <= recallWindow()
+	if !isAdmin && !withinWindow {
+		return db.Message{}, ErrRecallNotAllowed
+	}
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return db.Message{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	qtx := s.queries.WithTx(tx)
+
+	recalled, err := qtx.RecallMessage(ctx, db.RecallMessageParams{
+		ID:             messageID,
+		ConversationID: conversationID,
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return db.Message{}, ErrMessageNotFound
+	}
+	if err != nil {
+		return db.Message{}, err
+	}
+	// The edit history would still reveal what was recalled.
+	if err := qtx.DeleteMessageEdits(ctx, messageID); err != nil {
+		return db.Message{}, err
+	}
+
+	uploaded, err := s.uploadedBy(ctx, msg)
+	if err != nil {
+		return db.Message{}, err
+	}
+	if uploaded {
+		err = qtx.ScheduleFileCleanup(ctx, db.ScheduleFileCleanupParams{
+			FilePath: msg.FilePath.String,
+			RunAfter: pgtype.Timestamptz{Time: time.Now().Add(fileCleanupDelay), Valid: true},
+		})
+		if err != nil {
+			return db.Message{}, err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return db.Message{}, err
+	}
+	return recalled, nil
+}
+
+// attachThreads fills in the quoted parent of every reply and the number
+// of replies each message received.
+func (s *ChatService) attachThreads(ctx context.Context, msgs []MessageResponse) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, len(msgs))
+	var parentIDs []int64
+	for i, m := range msgs {
+		ids[i] = m.ID
+		if m.ReplyToID.Valid {
+			parentIDs = append(parentIDs, m.ReplyToID.Int64)
+		}
+	}
+
+	quotes, err := loadQuotes(ctx, s.queries, parentIDs)
+	if err != nil {
+		return err
+	}
+	counts, err := s.queries.CountRepliesByParentIDs(ctx, ids)
+	if err != nil {
+		return err
+	}
+	countByID := make(map[int64]int64, len(counts))
+	for _, c := range counts {
+		countByID[c.ParentID] = c.ReplyCount
+	}
+
+	for i := range msgs {
+		msgs[i].ReplyTo = quotes[msgs[i].ReplyToID.Int64]
+		msgs[i].ReplyCount = countByID[msgs[i].ID]
+	}
+	return nil
+}
+
+// attachReactions fills in the aggregated reactions of every message, with
+// reacted_by_me computed for userID.
+func (s *ChatService) attachReactions(ctx context.Context, userID string, msgs []MessageResponse) error {
+	ids := make([]int64, len(msgs))
+	for i, m := range msgs {
+		ids[i] = m.ID
+	}
+
+	reactions, err := loadReactions(ctx, s.queries, userID, ids)
+	if err != nil {
+		return err
+	}
+	for i := range msgs {
+		msgs[i].Reactions = reactions[msgs[i].ID]
+		if msgs[i].Reactions == nil {
+			msgs[i].Reactions = []ReactionSummary{}
+		}
+	}
+	return nil
+}
+
+// GetThread returns a message together with the replies posted after
+// afterID, oldest first.
+func (s *ChatService) GetThread(ctx context.Context, userID string, conversationID, messageID, afterID int64, limit int32) (*Thread, error) {
+	ok, err := s.isMember(ctx, conversationID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrNotMember
+	}
+
+	parent, err := s.queries.GetMessageByID(ctx, db.GetMessageByIDParams{
+		ID:             messageID,
+		ConversationID: conversationID,
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	replies, err := s.queries.ListThreadReplies(ctx, db.ListThreadRepliesParams{
+		ConversationID: conversationID,
+		ParentID:       pgtype.Int8{Int64: messageID, Valid: true},
+		AfterID:        afterID,
+		UserID:         userID,
+		LimitCount:     limit,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	all := make([]MessageResponse, 0, len(replies)+1)
+	for _, m := range append([]db.Message{parent}, replies...) {
+		res := MessageResponse{
+			Message: m,
+			Edited:  m.EditedAt.Valid,
+		}
+		if m.Type.String == "file" && m.FilePath.String != "" {
+			signedURL, err := storage.GetPresignedURL(m.FilePath.String)
+			if err != nil {
+				log.Printf("Error signing URL for message %d: %v", m.ID, err)
+			} else {
+				res.FileURL = signedURL
+			}
+		}
+		all = append(all, res)
+	}
+	if err := s.attachThreads(ctx, all); err != nil {
+		return nil, err
+	}
+	if err := s.attachReactions(ctx, userID, all); err != nil {
+		return nil, err
+	}
+
+	return &Thread{
+		Parent:  all[0],
+		Replies: all[1:],
+	}, nil
+}
+
+func (s *ChatService) PinMessage(ctx context.Context, userID, userName string, conversationID, messageID int64) (*db.Message, error) {
+	return PinMessage(ctx, s.pool, s.queries, userID, userName, conversationID, messageID)
+}
+
+func (s *ChatService) UnpinMessage(ctx context.Context, userID string, conversationID, messageID int64) (bool, error) {
+	return UnpinMessage(ctx, s.queries, userID, conversationID, messageID)
+}
+
+func (s *ChatService) ListPinnedMessages(ctx context.Context, userID string, conversationID int64) ([]PinnedMessage, error) {
+	ok, err := s.isMember(ctx, conversationID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrNotMember
+	}
+	return s.listPinned(ctx, userID, conversationID)
+}
+
+// listPinned returns the pinned messages of a conversation, most recently
+// pinned first, with their senders and pinners enriched.
+func (s *ChatService) listPinned(ctx context.Context, userID string, conversationID int64) ([]PinnedMessage, error) {
+	rows, err := s.queries.ListPinnedMessages(ctx, conversationID)
+	if err != nil {
+		return nil, err
+	}
+
+	userIDs := make([]string, 0, 2*len(rows))
+	msgs := make([]MessageResponse, len(rows))
+	for i, r := range rows {
+		userIDs = append(userIDs, r.Message.SenderID, r.PinnedBy)
+		msgs[i] = MessageResponse{
+			Message: r.Message,
+			Edited:  r.Message.EditedAt.Valid,
+		}
+		if r.Message.Type.String == "file" && r.Message.FilePath.String != "" {
+			signedURL, err := storage.GetPresignedURL(r.Message.FilePath.String)
+			if err != nil {
+				log.Printf("Error signing URL for message %d: %v", r.Message.ID, err)
+			} else {
+				msgs[i].FileURL = signedURL
+			}
+		}
+	}
+	if err := s.attachThreads(ctx, msgs); err != nil {
+		return nil, err
+	}
+	if err := s.attachReactions(ctx, userID, msgs); err != nil {
+		return nil, err
+	}
+
+	userMap, err := s.userClient.EnrichUsers(ctx, userIDs)
+	if err != nil {
+		log.Printf("Warning: failed to enrich some users: %v", err)
+	}
+	if userMap == nil {
+		userMap = make(map[string]client.UserInfo)
+	}
+
+	pinned := make([]PinnedMessage, len(rows))
+	for i, r := range rows {
+		sender := userMap[r.Message.SenderID]
+		pinned[i] = PinnedMessage{
+			Message:      msgs[i],
+			SenderName:   sender.Name,
+			SenderAvatar: sender.Avatar,
+			PinnedBy:     r.PinnedBy,
+			PinnedByName: userMap[r.PinnedBy].Name,
+			PinnedAt:     r.PinnedAt.Time,
+		}
+	}
+	return pinned, nil
+}
+
+// PrepareForward builds a copy of every source message for every target
+// conversation, ready to go through the persistence pipeline. File
+// messages keep pointing at the original object in storage. Each copy's
+// client_msg_id is derived from the caller's idempotency key, so a retried
+// forward is deduplicated by the DB worker instead of posted twice.
+func (s *ChatService) PrepareForward(ctx context.Context, userID, userName, idempotencyKey string, messageIDs, conversationIDs []int64) ([]Message, error) {
+	if len(messageIDs) > maxForwardMessages || len(conversationIDs) > maxForwardTargets {
+		return nil, ErrTooManyForwards
+	}
+
+	sources, err := s.queries.ListMessagesByIDs(ctx, messageIDs)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[int64]db.Message, len(sources))
+	for _, m := range sources {
+		byID[m.ID] = m
+	}
+
+	checked := make(map[int64]bool)
+	checkMember := func(convID int64) error {
+		if checked[convID] {
+			return nil
+		}
+		ok, err := s.isMember(ctx, convID, userID)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrNotMember
+		}
+		checked[convID] = true
+		return nil
+	}
+
+	for _, id := range messageIDs {
+		m, ok := byID[id]
+		if !ok || m.IsDeleted.Bool || m.Type.String == MessageTypeSystem {
+			return nil, ErrMessageNotFound
+		}
+		if err := checkMember(m.ConversationID); err != nil {
+			return nil, err
+		}
+	}
+	for _, convID := range conversationIDs {
+		if err := checkMember(convID); err != nil {
+			return nil, err
+		}
+	}
+
+	var out []Message
+	for _, convID := range conversationIDs {
+		for _, id := range messageIDs {
+			m := byID[id]
+			origin := m.ID
+			if m.ForwardedFrom.Valid {
+				origin = m.ForwardedFrom.Int64
+			}
+			out = append(out, Message{
+				ClientMsgID:    forwardClientMsgID(userID, idempotencyKey, id, convID),
+				Type:           m.Type.String,
+				ConversationID: convID,
+				SenderID:       userID,
+				SenderName:     userName,
+				Content:        m.Content.String,
+				FileName:       m.FileName.String,
+				FileID:         m.FileID.String,
+				FilePath:       m.FilePath.String,
+				FileType:       m.FileType.String,
+				FileSize:       m.FileSize.Int64,
+				ForwardedFrom:  origin,
+			})
+		}
+	}
+	return out, nil
+}
+
+func forwardClientMsgID(userID, idempotencyKey string, messageID, conversationID int64) string {
+	name := fmt.Sprintf("%s:%s:%d:%d", userID, idempotencyKey, messageID, conversationID)
+	return uuid.NewSHA1(uuid.NameSpaceOID, []byte(name)).String()
+}
+
+// SearchMessages runs a full-text search over the conversations the user
+// participates in. Pass NextCursor back as BeforeID for the next page.
+func (s *ChatService) SearchMessages(ctx context.Context, userID string, f SearchFilter) (*SearchResult, error) {
+	params := db.SearchMessagesParams{
+		UserID:         userID,
+		Query:          f.Query,
+		ConversationID: f.ConversationID,
+		SenderID:       f.SenderID,
+		MsgType:        f.Type,
+		BeforeID:       f.BeforeID,
+		LimitCount:     f.Limit,
+	}
+	if f.From != nil {
+		params.FromTime = pgtype.Timestamptz{Time: *f.From, Valid: true}
+	}
+	if f.To != nil {
+		params.ToTime = pgtype.Timestamptz{Time: *f.To, Valid: true}
+	}
+
+	rows, err := s.queries.SearchMessages(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &SearchResult{Results: rows}
+	if result.Results == nil {
+		result.Results = []db.SearchMessagesRow{}
+	}
+	if len(rows) == int(f.Limit) {
+		result.NextCursor = rows[len(rows)-1].ID
+	}
+	return result, nil
+}
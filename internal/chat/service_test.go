@@ -0,0 +1,601 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"corechain-communication/internal/config"
+	"corechain-communication/internal/db"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// newTestService connects to the Postgres named by TEST_DATABASE_URL and
+// migrates it. Tests using it are skipped when the variable is unset.
+func newTestService(t *testing.T) *ChatService {
+	t.Helper()
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	if _, err := config.LoadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	db.RunMigration("file://../db/migration", url)
+	// The participant cache falls back to the DB when Redis is unreachable.
+	db.InitRedis()
+
+	pool, err := pgxpool.New(context.Background(), url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Close)
+	return NewChatService(db.New(pool), pool, nil)
+}
+
+// testUserID makes name unique per test run while keeping it within the
+// 25 characters user IDs are stored in.
+func testUserID(name string) string {
+	return name + "-" + strings.ReplaceAll(uuid.NewString(), "-", "")[:12]
+}
+
+// newTestConversation creates a group with the given members, the first one
+// being its admin. Their IDs are made unique with testUserID.
+func newTestConversation(t *testing.T, s *ChatService, members ...string) (int64, []string) {
+	t.Helper()
+	ctx := context.Background()
+	conv, err := s.queries.CreateConversation(ctx, db.CreateConversationParams{
+		Name:    pgtype.Text{String: t.Name(), Valid: true},
+		IsGroup: pgtype.Bool{Bool: true, Valid: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userIDs := make([]string, len(members))
+	for i, m := range members {
+		userIDs[i] = testUserID(m)
+		role := RoleMember
+		if i == 0 {
+			role = RoleAdmin
+		}
+		err := s.queries.AddParticipant(ctx, db.AddParticipantParams{
+			ConversationID: conv.ID,
+			UserID:         userIDs[i],
+			Role:           pgtype.Text{String: role, Valid: true},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return conv.ID, userIDs
+}
+
+func newTestMessage(t *testing.T, s *ChatService, conversationID int64, senderID, content string) db.Message {
+	t.Helper()
+	msg, err := s.queries.CreateMessage(context.Background(), db.CreateMessageParams{
+		ConversationID: conversationID,
+		SenderID:       senderID,
+		Content:        pgtype.Text{String: content, Valid: true},
+		Type:           pgtype.Text{String: "text", Valid: true},
+		ClientMsgID:    pgtype.Text{String: uuid.NewString(), Valid: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func TestEditMessage(t *testing.T) {
+	s := newTestService(t)
+	ctx := context.Background()
+	convID, users := newTestConversation(t, s, "alice", "bob", "carol")
+	alice, bob, carol := users[0], users[1], users[2]
+
+	mine := newTestMessage(t, s, convID, alice, "first draft")
+	theirs := newTestMessage(t, s, convID, bob, "bob's")
+	left := newTestMessage(t, s, convID, carol, "before leaving")
+	err := s.queries.RemoveParticipant(ctx, db.RemoveParticipantParams{ConversationID: convID, UserID: carol})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		userID  string
+		msgID   int64
+		content string
+		wantErr error
+	}{
+		{"own message", alice, mine.ID, "second draft", nil},
+		{"empty content", alice, mine.ID, "  ", ErrEmptyContent},
+		{"someone else's message", alice, theirs.ID, "hijacked", ErrMessageNotFound},
+		{"after leaving", carol, left.ID, "edited from outside", ErrNotMember},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := s.EditMessage(ctx, tt.userID, convID, tt.msgID, tt.content)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("EditMessage() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	edits, err := s.ListMessageEdits(ctx, alice, convID, mine.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(edits) != 1 || edits[0].Content.String != "first draft" {
+		t.Errorf("edit history = %+v, want the first draft only", edits)
+	}
+}
+
+func TestRecallClearsEditHistory(t *testing.T) {
+	s := newTestService(t)
+	ctx := context.Background()
+	convID, users := newTestConversation(t, s, "alice", "bob")
+	alice := users[0]
+
+	msg := newTestMessage(t, s, convID, alice, "first draft")
+	if _, err := s.EditMessage(ctx, alice, convID, msg.ID, "second draft"); err != nil {
+		t.Fatal(err)
+	}
+	edits, err := s.ListMessageEdits(ctx, alice, convID, msg.ID)
+	if err != nil || len(edits) != 1 {
+		t.Fatalf("edit history before recall = %v, %v", edits, err)
+	}
+
+	if _, err := s.DeleteMessage(ctx, alice, convID, msg.ID, DeleteScopeEveryone); err != nil {
+		t.Fatal(err)
+	}
+	edits, err = s.ListMessageEdits(ctx, alice, convID, msg.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(edits) != 0 {
+		t.Errorf("recalled message still has %d edits: %+v", len(edits), edits)
+	}
+}
+
+func TestCatchUpSkipsHiddenMessages(t *testing.T) {
+	s := newTestService(t)
+	ctx := context.Background()
+	convID, users := newTestConversation(t, s, "alice", "bob")
+	alice, bob := users[0], users[1]
+
+	hidden := newTestMessage(t, s, convID, bob, "deleted for alice")
+	kept := newTestMessage(t, s, convID, bob, "still visible")
+	if _, err := s.DeleteMessage(ctx, alice, convID, hidden.ID, DeleteScopeMe); err != nil {
+		t.Fatal(err)
+	}
+
+	all, err := s.queries.ListMessagesSince(ctx, db.ListMessagesSinceParams{
+		UserID:     alice,
+		AfterID:    hidden.ID - 1,
+		LimitCount: 10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conv, err := s.queries.ListConversationMessagesSince(ctx, db.ListConversationMessagesSinceParams{
+		ConversationID: convID,
+		AfterID:        hidden.ID - 1,
+		UserID:         alice,
+		LimitCount:     10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, msgs := range map[string][]db.Message{"ListMessagesSince": all, "ListConversationMessagesSince": conv} {
+		if len(msgs) != 1 || msgs[0].ID != kept.ID {
+			t.Errorf("%s returned %d messages, want only %d", name, len(msgs), kept.ID)
+		}
+	}
+}
+
+func TestRecallCleansUpOnlyOwnUploads(t *testing.T) {
+	s := newTestService(t)
+	ctx := context.Background()
+	convID, users := newTestConversation(t, s, "alice", "bob")
+	alice, bob := users[0], users[1]
+
+	upload := func(owner string) string {
+		path := uuid.NewString() + ".png"
+		if err := s.queries.RecordUpload(ctx, db.RecordUploadParams{FilePath: path, UserID: owner}); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	tests := []struct {
+		name        string
+		path        string
+		wantCleanup bool
+	}{
+		{"own upload", upload(alice), true},
+		{"someone else's upload", upload(bob), false},
+		{"untracked file", uuid.NewString() + ".png", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := s.queries.CreateMessage(ctx, db.CreateMessageParams{
+				ConversationID: convID,
+				SenderID:       alice,
+				Type:           pgtype.Text{String: "file", Valid: true},
+				FilePath:       pgtype.Text{String: tt.path, Valid: true},
+				ClientMsgID:    pgtype.Text{String: uuid.NewString(), Valid: true},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := s.DeleteMessage(ctx, alice, convID, msg.ID, DeleteScopeEveryone); err != nil {
+				t.Fatal(err)
+			}
+
+			var n int
+			err = s.pool.QueryRow(ctx, "SELECT COUNT(*) FROM file_cleanups WHERE file_path = $1", tt.path).Scan(&n)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := n > 0; got != tt.wantCleanup {
+				t.Errorf("cleanup scheduled = %v, want %v", got, tt.wantCleanup)
+			}
+		})
+	}
+}
+
+func TestReactionSummaries(t *testing.T) {
+	s := newTestService(t)
+	ctx := context.Background()
+	convID, users := newTestConversation(t, s, "alice", "bob")
+	alice, bob := users[0], users[1]
+	reacted := newTestMessage(t, s, convID, alice, "good news")
+	quiet := newTestMessage(t, s, convID, alice, "no reactions")
+
+	for _, r := range []struct {
+		userID, emoji string
+		wantAdded     int64
+	}{
+		{alice, "👍", 1},
+		{bob, "👍", 1},
+		{bob, "❤️", 1},
+		{alice, "👍", 0}, // repeating an add is a no-op
+	} {
+		added, err := s.queries.AddReaction(ctx, db.AddReactionParams{MessageID: reacted.ID, UserID: r.userID, Emoji: r.emoji})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if added != r.wantAdded {
+			t.Errorf("%s adding %s changed %d rows, want %d", r.userID, r.emoji, added, r.wantAdded)
+		}
+	}
+
+	msgs := []MessageResponse{{Message: reacted}, {Message: quiet}}
+	if err := s.attachReactions(ctx, alice, msgs); err != nil {
+		t.Fatal(err)
+	}
+	want := []ReactionSummary{
+		{Emoji: "👍", Count: 2, ReactedByMe: true},
+		{Emoji: "❤️", Count: 1, ReactedByMe: false},
+	}
+	if !slices.Equal(msgs[0].Reactions, want) {
+		t.Errorf("reactions = %+v, want %+v", msgs[0].Reactions, want)
+	}
+	if msgs[1].Reactions == nil || len(msgs[1].Reactions) != 0 {
+		t.Errorf("message without reactions got %#v, want an empty list", msgs[1].Reactions)
+	}
+}
+
+func TestConcurrentPinsRespectLimit(t *testing.T) {
+	s := newTestService(t)
+	ctx := context.Background()
+	convID, users := newTestConversation(t, s, "alice")
+	alice := users[0]
+
+	var wg sync.WaitGroup
+	for range maxPinnedMessages + 5 {
+		msg := newTestMessage(t, s, convID, alice, "pin me")
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := s.PinMessage(ctx, alice, "Alice", convID, msg.ID); err != nil && err != ErrPinLimitReached {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	count, err := s.queries.CountPinnedMessages(ctx, convID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != maxPinnedMessages {
+		t.Errorf("%d messages pinned, want %d", count, maxPinnedMessages)
+	}
+}
+
+func TestRepinAtLimitIsNoop(t *testing.T) {
+	s := newTestService(t)
+	ctx := context.Background()
+	convID, users := newTestConversation(t, s, "alice")
+	alice := users[0]
+
+	var first db.Message
+	for i := range maxPinnedMessages {
+		msg := newTestMessage(t, s, convID, alice, "pin me")
+		if i == 0 {
+			first = msg
+		}
+		if _, err := s.PinMessage(ctx, alice, "Alice", convID, msg.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	system, err := s.PinMessage(ctx, alice, "Alice", convID, first.ID)
+	if err != nil || system != nil {
+		t.Errorf("re-pin at the limit = %v, %v, want a no-op", system, err)
+	}
+	extra := newTestMessage(t, s, convID, alice, "one too many")
+	if _, err := s.PinMessage(ctx, alice, "Alice", convID, extra.ID); !errors.Is(err, ErrPinLimitReached) {
+		t.Errorf("pin over the limit error = %v, want %v", err, ErrPinLimitReached)
+	}
+}
+
+func TestPrepareForward(t *testing.T) {
+	s := newTestService(t)
+	ctx := context.Background()
+	source, users := newTestConversation(t, s, "alice", "bob")
+	alice, bob := users[0], users[1]
+	target, _ := newTestConversation(t, s, "alice")
+	msg := newTestMessage(t, s, source, bob, "pass it on")
+
+	copies, err := s.PrepareForward(ctx, alice, "Alice", "key-1", []int64{msg.ID}, []int64{target})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(copies) != 1 || copies[0].ConversationID != target || copies[0].SenderID != alice ||
+		copies[0].ForwardedFrom != msg.ID || copies[0].Content != "pass it on" {
+		t.Fatalf("copies = %+v", copies)
+	}
+
+	// A retry with the same key must produce the same client_msg_id so the
+	// DB worker drops the duplicate; a new key is a new forward.
+	retry, err := s.PrepareForward(ctx, alice, "Alice", "key-1", []int64{msg.ID}, []int64{target})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if retry[0].ClientMsgID != copies[0].ClientMsgID {
+		t.Errorf("retry client_msg_id = %s, want %s", retry[0].ClientMsgID, copies[0].ClientMsgID)
+	}
+	again, err := s.PrepareForward(ctx, alice, "Alice", "key-2", []int64{msg.ID}, []int64{target})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again[0].ClientMsgID == copies[0].ClientMsgID {
+		t.Error("a new idempotency key reused the client_msg_id")
+	}
+
+	// Bob is not in the target conversation.
+	if _, err := s.PrepareForward(ctx, bob, "Bob", "key-3", []int64{msg.ID}, []int64{target}); !errors.Is(err, ErrNotMember) {
+		t.Errorf("forward into a foreign conversation error = %v, want %v", err, ErrNotMember)
+	}
+}
+
+func TestSearchMessagesCursor(t *testing.T) {
+	s := newTestService(t)
+	ctx := context.Background()
+	convID, users := newTestConversation(t, s, "alice", "bob")
+	alice, bob := users[0], users[1]
+	other, _ := newTestConversation(t, s, "bob")
+
+	var want []int64
+	for range 5 {
+		want = append(want, newTestMessage(t, s, convID, bob, "deploy the rocket").ID)
+	}
+	newTestMessage(t, s, convID, bob, "unrelated chatter")
+	// Alice is not in the other conversation, so its hits stay hidden.
+	newTestMessage(t, s, other, bob, "deploy the rocket")
+	slices.Reverse(want)
+
+	var got []int64
+	filter := SearchFilter{Query: "rocket", Limit: 2}
+	for page := 0; ; page++ {
+		if page > len(want) {
+			t.Fatal("pagination did not terminate")
+		}
+		res, err := s.SearchMessages(ctx, alice, filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, row := range res.Results {
+			got = append(got, row.ID)
+		}
+		if res.NextCursor == 0 {
+			break
+		}
+		filter.BeforeID = res.NextCursor
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("paged results = %v, want %v", got, want)
+	}
+
+	future := time.Now().Add(time.Hour)
+	res, err := s.SearchMessages(ctx, alice, SearchFilter{Query: "rocket", From: &future, Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Results) != 0 {
+		t.Errorf("from filter in the future returned %d results", len(res.Results))
+	}
+}
+
+func TestPinNotice(t *testing.T) {
+	s := newTestService(t)
+	ctx := context.Background()
+	convID, users := newTestConversation(t, s, "alice")
+	alice := users[0]
+	msg := newTestMessage(t, s, convID, alice, "pin me")
+
+	system, err := s.PinMessage(ctx, alice, "Alice", convID, msg.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if system == nil || system.Type.String != MessageTypeSystem || system.Content.String != "Alice pinned a message" {
+		t.Fatalf("system message = %+v", system)
+	}
+	// The notice refers to the message through its payload only, so it
+	// does not count as a reply in the message's thread.
+	if system.ReplyToID.Valid {
+		t.Errorf("pin notice replies to %d", system.ReplyToID.Int64)
+	}
+	var payload SystemPayload
+	if err := json.Unmarshal(system.SystemPayload, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Action != SystemMessagePinned || payload.MessageID != msg.ID || payload.Actor.ID != alice {
+		t.Errorf("payload = %+v", payload)
+	}
+}
+
+func TestPinConversationLimit(t *testing.T) {
+	s := newTestService(t)
+	ctx := context.Background()
+	first, users := newTestConversation(t, s, "alice")
+	alice := users[0]
+	convIDs := []int64{first}
+	for range maxPinnedConversations + 2 {
+		convID, _ := newTestConversation(t, s, "bob")
+		if err := s.queries.AddParticipant(ctx, db.AddParticipantParams{ConversationID: convID, UserID: alice}); err != nil {
+			t.Fatal(err)
+		}
+		convIDs = append(convIDs, convID)
+	}
+
+	// Without the lock every request could count the same pins and all of
+	// them would pass the check.
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		pinned []int64
+	)
+	for _, convID := range convIDs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := s.PinConversation(ctx, alice, convID, true)
+			switch {
+			case err == nil:
+				mu.Lock()
+				pinned = append(pinned, convID)
+				mu.Unlock()
+			case !errors.Is(err, ErrPinnedConversationsLimit):
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if len(pinned) != maxPinnedConversations {
+		t.Fatalf("%d conversations pinned, want %d", len(pinned), maxPinnedConversations)
+	}
+
+	// Pinning a pinned conversation again only moves it to the top.
+	if _, err := s.PinConversation(ctx, alice, pinned[0], true); err != nil {
+		t.Errorf("re-pin at the limit: %v", err)
+	}
+}
+
+func TestMuteConversation(t *testing.T) {
+	s := newTestService(t)
+	ctx := context.Background()
+	convID, users := newTestConversation(t, s, "alice")
+	alice := users[0]
+
+	past := time.Now().Add(-time.Minute)
+	if _, err := s.MuteConversation(ctx, alice, convID, &past); !errors.Is(err, ErrInvalidMuteTime) {
+		t.Errorf("mute in the past error = %v, want %v", err, ErrInvalidMuteTime)
+	}
+	if _, err := s.MuteConversation(ctx, testUserID("mallory"), convID, nil); !errors.Is(err, ErrNotMember) {
+		t.Errorf("outsider mute error = %v, want %v", err, ErrNotMember)
+	}
+
+	prefs, err := s.MuteConversation(ctx, alice, convID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !prefs.IsMuted || prefs.MutedUntil != nil {
+		t.Errorf("indefinite mute = %+v", prefs)
+	}
+	muted, err := s.queries.ListMutedParticipants(ctx, convID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(muted, []string{alice}) {
+		t.Errorf("muted participants = %v, want [%s]", muted, alice)
+	}
+
+	if prefs, err = s.UnmuteConversation(ctx, alice, convID); err != nil || prefs.IsMuted {
+		t.Errorf("unmute = %+v, %v", prefs, err)
+	}
+}
+
+func TestMarkRead(t *testing.T) {
+	s := newTestService(t)
+	ctx := context.Background()
+	convID, users := newTestConversation(t, s, "alice", "bob")
+	alice, bob := users[0], users[1]
+	first := newTestMessage(t, s, convID, alice, "one")
+	last := newTestMessage(t, s, convID, alice, "two")
+	err := s.queries.UpdateConversationLastMessage(ctx, db.UpdateConversationLastMessageParams{
+		ID:            convID,
+		LastMessageID: pgtype.Int8{Int64: last.ID, Valid: true},
+		LastMessageAt: last.CreatedAt,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	receipt, err := MarkRead(ctx, s.queries, bob, convID, first.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if receipt == nil || receipt.LastReadMessageID != first.ID || receipt.ReadAt == nil {
+		t.Fatalf("receipt = %+v", receipt)
+	}
+	// A redelivered frame leaves the position, and the members, alone.
+	if receipt, err = MarkRead(ctx, s.queries, bob, convID, first.ID); err != nil || receipt != nil {
+		t.Errorf("repeated read = %+v, %v, want no receipt", receipt, err)
+	}
+	if _, err := MarkRead(ctx, s.queries, bob, convID, last.ID+1); !errors.Is(err, ErrReadBeyondLatest) {
+		t.Errorf("read past the latest error = %v, want %v", err, ErrReadBeyondLatest)
+	}
+	if _, err := MarkRead(ctx, s.queries, testUserID("mallory"), convID, first.ID); !errors.Is(err, ErrNotMember) {
+		t.Errorf("outsider read error = %v, want %v", err, ErrNotMember)
+	}
+	if _, err := MarkRead(ctx, s.queries, bob, convID, last.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := MarkRead(ctx, s.queries, bob, convID, first.ID); !errors.Is(err, ErrReadBackwards) {
+		t.Errorf("backwards read error = %v, want %v", err, ErrReadBackwards)
+	}
+
+	// Bob has seen both messages; alice, their sender, is never listed.
+	readers, err := s.queries.ListMessageReaders(ctx, db.ListMessageReadersParams{
+		ConversationID: convID,
+		MessageID:      first.ID,
+		SenderID:       alice,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(readers) != 1 || readers[0].UserID != bob {
+		t.Errorf("readers = %+v, want only %s", readers, bob)
+	}
+}
@@ -0,0 +1,154 @@
+package chat
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"log"
+	"time"
+
+	"corechain-communication/internal/config"
+
+	"github.com/gorilla/websocket"
+)
+
+// What happens to frames for a client whose Send queue is full.
+const (
+	// SlowConsumerCoalesce keeps only the latest typing or presence frame
+	// per conversation and user, and handles anything else like
+	// SlowConsumerResync.
+	SlowConsumerCoalesce = "coalesce"
+
+	// SlowConsumerResync drops the frames and sends resync_required once
+	// the queue has drained, so the client re-fetches what it missed.
+	SlowConsumerResync = "resync"
+
+	// SlowConsumerClose closes the connection with CloseSlowConsumer.
+	SlowConsumerClose = "close"
+)
+
+// CloseSlowConsumer is the close code of a connection that could not keep
+// up with its frames. Clients should reconnect with a catch-up cursor.
+const CloseSlowConsumer = 4008
+
+const defaultSendQueueSize = 256
+
+// slowConsumerStats counts frames "coalesced" and "dropped" on full queues
+// and connections "evicted" for it. It is served on /debug/vars of the
+// admin listener.
+var slowConsumerStats = expvar.NewMap("chat_slow_consumer")
+
+func sendQueueSize() int {
+	if n := config.Get().WSSendQueueSize; n > 0 {
+		return n
+	}
+	return defaultSendQueueSize
+}
+
+func slowConsumerPolicy() string {
+	p := config.Get().WSSlowConsumerPolicy
+	switch p {
+	case SlowConsumerCoalesce, SlowConsumerResync, SlowConsumerClose:
+		return p
+	}
+	if p != "" {
+		log.Printf("Unknown WS_SLOW_CONSUMER_POLICY %q, using %s", p, SlowConsumerCoalesce)
+	}
+	return SlowConsumerCoalesce
+}
+
+// overflow applies the slow consumer policy to a frame that did not fit
+// in Send. key returns the coalescing key of the frame, "" when it has
+// none; it may be nil. The caller holds c.mu. Like trySend it reports
+// whether the client will still get the frame or learn that it missed it.
+func (c *Client) overflow(data []byte, key func() string) bool {
+	switch c.SlowConsumerPolicy {
+	case SlowConsumerClose:
+		c.evicted = true
+		slowConsumerStats.Add("evicted", 1)
+		log.Printf("Closing session %s of user %s: send queue full", c.ID, c.UserID)
+		go c.closeWith(CloseSlowConsumer, "send queue full")
+		return false
+	case SlowConsumerResync:
+	default:
+		if key != nil {
+			if k := key(); k != "" {
+				if c.coalesced == nil {
+					c.coalesced = make(map[string][]byte)
+				}
+				c.coalesced[k] = data
+				slowConsumerStats.Add("coalesced", 1)
+				return true
+			}
+		}
+	}
+
+	if !c.resync {
+		log.Printf("Send queue of session %s (user %s) is full, dropping frames until it drains", c.ID, c.UserID)
+	}
+	c.resync = true
+	slowConsumerStats.Add("dropped", 1)
+	return true
+}
+
+// flushBacklog writes what was held back while Send was full. It is only
+// called by WritePump, once Send is empty.
+func (c *Client) flushBacklog() error {
+	c.mu.Lock()
+	coalesced, resync := c.coalesced, c.resync
+	c.coalesced, c.resync = nil, false
+	c.mu.Unlock()
+
+	for _, data := range coalesced {
+		if err := c.write(data); err != nil {
+			return err
+		}
+	}
+	if !resync {
+		return nil
+	}
+	// Sent after the last dropped frame, so a resync covers all of them.
+	data, err := json.Marshal(SyncFrame{Type: "resync_required", Reason: "slow_consumer"})
+	if err != nil {
+		return err
+	}
+	return c.write(c.encode(data))
+}
+
+// closeWith closes the connection with a close frame. It may block for up
+// to writeWait, so the hub always runs it on its own goroutine. Closing
+// the socket ends ReadPump, which unregisters the client.
+func (c *Client) closeWith(code int, reason string) {
+	msg := websocket.FormatCloseMessage(code, reason)
+	if err := c.Conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(writeWait)); err != nil {
+		log.Printf("Failed to send close frame to session %s: %v", c.ID, err)
+	}
+	c.Conn.Close()
+}
+
+// coalesceKey identifies the state a typing or presence frame describes,
+// so that only the latest one needs to reach a slow client. Other frames
+// have no key.
+func (f *outboundFrame) coalesceKey() string {
+	if f.keyed {
+		return f.key
+	}
+	f.keyed = true
+
+	var head struct {
+		Type           string `json:"type"`
+		ConversationID int64  `json:"conversation_id"`
+		SenderID       string `json:"sender_id"`
+		UserID         string `json:"user_id"`
+	}
+	if err := json.Unmarshal(f.data, &head); err != nil {
+		return ""
+	}
+	switch head.Type {
+	case "typing_start", "typing_stop":
+		f.key = fmt.Sprintf("typing:%d:%s", head.ConversationID, head.SenderID)
+	case "presence_changed":
+		f.key = "presence:" + head.UserID
+	}
+	return f.key
+}
@@ -0,0 +1,105 @@
+package chat
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestSlowConsumerPolicies(t *testing.T) {
+	typing := func(eventType string) *outboundFrame {
+		return newOutboundFrame([]byte(`{"type":"` + eventType + `","conversation_id":1,"sender_id":"u2"}`))
+	}
+	message := newOutboundFrame([]byte(`{"type":"text","conversation_id":1,"content":"hi"}`))
+
+	c := newTestClient(nil, "u1", "web")
+	c.Send = make(chan []byte, 1)
+	if !c.trySendFrame(message) {
+		t.Fatal("frame refused with room in the queue")
+	}
+
+	// The queue is full: only the latest typing state is kept.
+	c.trySendFrame(typing("typing_start"))
+	if !c.trySendFrame(typing("typing_stop")) {
+		t.Fatal("coalesced frame reported as lost")
+	}
+	if len(c.coalesced) != 1 || string(c.coalesced["typing:1:u2"]) != string(typing("typing_stop").data) {
+		t.Errorf("coalesced = %q", c.coalesced)
+	}
+	if c.resync {
+		t.Error("typing frames should not require a resync")
+	}
+
+	if !c.trySendFrame(message) {
+		t.Fatal("dropped frame reported as lost")
+	}
+	if !c.resync {
+		t.Error("dropping a message should require a resync")
+	}
+
+	r := newTestClient(nil, "u1", "mobile")
+	r.Send = make(chan []byte, 1)
+	r.SlowConsumerPolicy = SlowConsumerResync
+	r.trySendFrame(message)
+	r.trySendFrame(typing("typing_start"))
+	if len(r.coalesced) != 0 || !r.resync {
+		t.Errorf("resync policy: coalesced %d frames, resync %v", len(r.coalesced), r.resync)
+	}
+}
+
+func TestCoalescedFrameNotFlushedAfterFresherOne(t *testing.T) {
+	typing := func(eventType string) *outboundFrame {
+		return newOutboundFrame([]byte(`{"type":"` + eventType + `","conversation_id":1,"sender_id":"u2"}`))
+	}
+	server, peer := newTestConn(t)
+	c := newTestClient(nil, "u1", "web")
+	c.Conn = server
+	c.Send = make(chan []byte, 1)
+
+	c.trySendFrame(newOutboundFrame([]byte(`{"type":"text","conversation_id":1,"content":"hi"}`)))
+	c.trySendFrame(typing("typing_start"))
+	<-c.Send // the write pump took the message
+	c.trySendFrame(typing("typing_stop"))
+	go c.WritePump()
+	t.Cleanup(c.close)
+
+	var last string
+	peer.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+	for {
+		_, data, err := peer.ReadMessage()
+		if err != nil {
+			break
+		}
+		var head struct{ Type string }
+		json.Unmarshal(data, &head)
+		last = head.Type
+	}
+	if last != "typing_stop" {
+		t.Errorf("last typing frame = %q, want typing_stop", last)
+	}
+}
+
+func TestSlowConsumerClose(t *testing.T) {
+	server, peer := newTestConn(t)
+	c := newTestClient(nil, "u1", "web")
+	c.Conn = server
+	c.Send = make(chan []byte, 1)
+	c.SlowConsumerPolicy = SlowConsumerClose
+
+	frame := newOutboundFrame([]byte(`{"type":"text","conversation_id":1,"content":"hi"}`))
+	c.trySendFrame(frame)
+	if c.trySendFrame(frame) {
+		t.Error("frame for an evicted client reported as delivered")
+	}
+	if c.trySendFrame(frame) {
+		t.Error("evicted client accepted another frame")
+	}
+
+	peer.SetReadDeadline(time.Now().Add(time.Second))
+	_, _, err := peer.ReadMessage()
+	if !websocket.IsCloseError(err, CloseSlowConsumer) {
+		t.Errorf("peer got %v, want close code %d", err, CloseSlowConsumer)
+	}
+}
@@ -0,0 +1,198 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"corechain-communication/internal/client"
+
+	"github.com/google/uuid"
+)
+
+// MessageTypeSystem marks messages produced by the server to record
+// conversation events in the timeline. Clients can never send them.
+const MessageTypeSystem = "system"
+
+const (
+	SystemGroupCreated     = "group_created"
+	SystemMembersAdded     = "members_added"
+	SystemMemberRemoved    = "member_removed"
+	SystemMemberLeft       = "member_left"
+	SystemGroupRenamed     = "group_renamed"
+	SystemAvatarChanged    = "avatar_changed"
+	SystemRoleChanged      = "role_changed"
+	SystemMessagePinned    = "message_pinned"
+	SystemMeetingStarted   = "meeting_started"
+	SystemMeetingScheduled = "meeting_scheduled"
+)
+
+// SystemUser names a user taking part in a system event. Name is resolved
+// when the event is recorded so old timelines keep reading correctly.
+type SystemUser struct {
+	ID   string `json:"id"`
+	Name string `json:"name,omitempty"`
+}
+
+// SystemPayload is the structured part of a system message; Content holds
+// its rendered text for previews and older clients.
+type SystemPayload struct {
+	Action  string       `json:"action"`
+	Actor   SystemUser   `json:"actor"`
+	Targets []SystemUser `json:"targets,omitempty"`
+
+	Name      string `json:"name,omitempty"`
+	Role      string `json:"role,omitempty"`
+	MessageID int64  `json:"message_id,omitempty"`
+
+	MeetingID    string `json:"meeting_id,omitempty"`
+	MeetingTitle string `json:"meeting_title,omitempty"`
+	RoomName     string `json:"room_name,omitempty"`
+	// StartsAt is set for scheduled meetings; clients render it in the
+	// reader's time zone.
+	StartsAt *time.Time `json:"starts_at,omitempty"`
+}
+
+// Render returns the text shown in conversation previews.
+func (p SystemPayload) Render() string {
+	actor := displayName(p.Actor)
+	switch p.Action {
+	case SystemGroupCreated:
+		return fmt.Sprintf("%s created the group %q", actor, p.Name)
+	case SystemMembersAdded:
+		return fmt.Sprintf("%s added %s", actor, joinNames(p.Targets))
+	case SystemMemberRemoved:
+		return fmt.Sprintf("%s removed %s", actor, joinNames(p.Targets))
+	case SystemMemberLeft:
+		return fmt.Sprintf("%s left the group", actor)
+	case SystemGroupRenamed:
+		return fmt.Sprintf("%s renamed the group to %q", actor, p.Name)
+	case SystemAvatarChanged:
+		return fmt.Sprintf("%s changed the group photo", actor)
+	case SystemRoleChanged:
+		if p.Role == RoleAdmin {
+			return fmt.Sprintf("%s made %s an admin", actor, joinNames(p.Targets))
+		}
+		return fmt.Sprintf("%s removed %s as admin", actor, joinNames(p.Targets))
+	case SystemMessagePinned:
+		return fmt.Sprintf("%s pinned a message", actor)
+	case SystemMeetingStarted:
+		if p.MeetingTitle != "" {
+			return fmt.Sprintf("%s started a meeting: %s", actor, p.MeetingTitle)
+		}
+		return fmt.Sprintf("%s started a meeting", actor)
+	case SystemMeetingScheduled:
+		if p.MeetingTitle != "" {
+			return fmt.Sprintf("%s scheduled a meeting: %s", actor, p.MeetingTitle)
+		}
+		return fmt.Sprintf("%s scheduled a meeting", actor)
+	}
+	return actor + " updated the conversation"
+}
+
+func displayName(u SystemUser) string {
+	if u.Name != "" {
+		return u.Name
+	}
+	return "Someone"
+}
+
+func joinNames(users []SystemUser) string {
+	names := make([]string, len(users))
+	for i, u := range users {
+		names[i] = displayName(u)
+	}
+	switch len(names) {
+	case 0:
+		return "nobody"
+	case 1:
+		return names[0]
+	}
+	return strings.Join(names[:len(names)-1], ", ") + " and " + names[len(names)-1]
+}
+
+// PostSystemMessage records a system message through the persistence
+// pipeline, so it is stored and broadcast like any other message. The
+// generated client_msg_id keeps Kafka redeliveries from duplicating it.
+func (h *Hub) PostSystemMessage(ctx context.Context, conversationID int64, payload SystemPayload) {
+	msg := Message{
+		ClientMsgID:    uuid.NewString(),
+		Type:           MessageTypeSystem,
+		ConversationID: conversationID,
+		SenderID:       payload.Actor.ID,
+		SenderName:     payload.Actor.Name,
+		Content:        payload.Render(),
+		System:         &payload,
+	}
+	failed := func(err error) {
+		log.Printf("Failed to queue %s system message for Conv %d: %v", payload.Action, conversationID, err)
+	}
+	if err := h.Enqueue(ctx, msg, failed); err != nil {
+		failed(err)
+	}
+}
+
+// SystemPayloadFor describes a group change as a system message, resolving
+// the names of everyone involved. It returns false for changes that are
+// not recorded in the timeline.
+func (s *ChatService) SystemPayloadFor(ctx context.Context, actorName string, event ConversationUpdatedEvent) (SystemPayload, bool) {
+	payload := SystemPayload{
+		Actor: SystemUser{ID: event.ActorID, Name: actorName},
+	}
+
+	var targetIDs []string
+	switch event.Action {
+	case "created":
+		payload.Action = SystemGroupCreated
+		payload.Name = event.Name
+	case "members_added":
+		if len(event.UserIDs) == 0 {
+			return SystemPayload{}, false
+		}
+		payload.Action = SystemMembersAdded
+		targetIDs = event.UserIDs
+	case "member_removed":
+		payload.Action = SystemMemberRemoved
+		targetIDs = event.UserIDs
+	case "member_left":
+		payload.Action = SystemMemberLeft
+	case "role_changed":
+		payload.Action = SystemRoleChanged
+		payload.Role = event.Role
+		targetIDs = event.UserIDs
+	case "info_updated":
+		switch {
+		case event.NameChanged:
+			payload.Action = SystemGroupRenamed
+			payload.Name = event.Name
+		case event.AvatarChanged:
+			payload.Action = SystemAvatarChanged
+		default:
+			return SystemPayload{}, false
+		}
+	default:
+		return SystemPayload{}, false
+	}
+
+	lookup := targetIDs
+	if payload.Actor.Name == "" {
+		lookup = append([]string{event.ActorID}, targetIDs...)
+	}
+	userMap, err := s.userClient.EnrichUsers(ctx, lookup)
+	if err != nil {
+		log.Printf("Warning: failed to enrich some users: %v", err)
+	}
+	if userMap == nil {
+		userMap = make(map[string]client.UserInfo)
+	}
+
+	if payload.Actor.Name == "" {
+		payload.Actor.Name = userMap[event.ActorID].Name
+	}
+	for _, id := range targetIDs {
+		payload.Targets = append(payload.Targets, SystemUser{ID: id, Name: userMap[id].Name})
+	}
+	return payload, true
+}
@@ -0,0 +1,45 @@
+package chat
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSystemPayloadRender(t *testing.T) {
+	alice := SystemUser{ID: "u1", Name: "Alice"}
+	bob := SystemUser{ID: "u2", Name: "Bob"}
+	carol := SystemUser{ID: "u3", Name: "Carol"}
+	startsAt := time.Date(2026, 10, 20, 9, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		payload SystemPayload
+		want    string
+	}{
+		{"group created", SystemPayload{Action: SystemGroupCreated, Actor: alice, Name: "Team"}, `Alice created the group "Team"`},
+		{"one member added", SystemPayload{Action: SystemMembersAdded, Actor: alice, Targets: []SystemUser{bob}}, "Alice added Bob"},
+		{"two members added", SystemPayload{Action: SystemMembersAdded, Actor: alice, Targets: []SystemUser{bob, carol}}, "Alice added Bob and Carol"},
+		{"three members added", SystemPayload{Action: SystemMembersAdded, Actor: alice, Targets: []SystemUser{bob, carol, {ID: "u4"}}}, "Alice added Bob, Carol and Someone"},
+		{"nobody added", SystemPayload{Action: SystemMembersAdded, Actor: alice}, "Alice added nobody"},
+		{"member removed", SystemPayload{Action: SystemMemberRemoved, Actor: alice, Targets: []SystemUser{bob}}, "Alice removed Bob"},
+		{"member left", SystemPayload{Action: SystemMemberLeft, Actor: bob}, "Bob left the group"},
+		{"renamed", SystemPayload{Action: SystemGroupRenamed, Actor: alice, Name: "Crew"}, `Alice renamed the group to "Crew"`},
+		{"avatar changed", SystemPayload{Action: SystemAvatarChanged, Actor: alice}, "Alice changed the group photo"},
+		{"made admin", SystemPayload{Action: SystemRoleChanged, Actor: alice, Role: RoleAdmin, Targets: []SystemUser{bob}}, "Alice made Bob an admin"},
+		{"admin removed", SystemPayload{Action: SystemRoleChanged, Actor: alice, Role: RoleMember, Targets: []SystemUser{bob}}, "Alice removed Bob as admin"},
+		{"message pinned", SystemPayload{Action: SystemMessagePinned, Actor: alice, MessageID: 7}, "Alice pinned a message"},
+		{"meeting started", SystemPayload{Action: SystemMeetingStarted, Actor: alice, MeetingTitle: "Standup"}, "Alice started a meeting: Standup"},
+		{"untitled meeting started", SystemPayload{Action: SystemMeetingStarted, Actor: alice}, "Alice started a meeting"},
+		{"meeting scheduled", SystemPayload{Action: SystemMeetingScheduled, Actor: alice, MeetingTitle: "Retro", StartsAt: &startsAt}, "Alice scheduled a meeting: Retro"},
+		{"untitled meeting scheduled", SystemPayload{Action: SystemMeetingScheduled, Actor: alice, StartsAt: &startsAt}, "Alice scheduled a meeting"},
+		{"unnamed actor", SystemPayload{Action: SystemMemberLeft, Actor: SystemUser{ID: "u9"}}, "Someone left the group"},
+		{"unknown action", SystemPayload{Action: "archived", Actor: alice}, "Alice updated the conversation"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.payload.Render(); got != tt.want {
+				t.Errorf("Render() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
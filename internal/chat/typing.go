@@ -0,0 +1,157 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// typingTimeout is how long a typing indicator survives without a
+	// refresh before the server sends typing_stop on the sender's behalf.
+	typingTimeout = 5 * time.Second
+
+	// typingThrottle is the minimum gap between two typing_start broadcasts
+	// from the same sender in the same conversation.
+	typingThrottle = 2 * time.Second
+)
+
+// TypingEvent is fanned out to the other members of a conversation. It is
+// never persisted nor sent to the notification topic.
+type TypingEvent struct {
+	Type           string `json:"type"`
+	ConversationID int64  `json:"conversation_id"`
+	SenderID       string `json:"sender_id"`
+	SenderName     string `json:"sender_name,omitempty"`
+}
+
+type typingKey struct {
+	conversationID int64
+	userID         string
+}
+
+type typingState struct {
+	userName  string
+	memberIDs []string
+	lastSent  time.Time
+	timer     *time.Timer
+}
+
+type typingTracker struct {
+	mu     sync.Mutex
+	active map[typingKey]*typingState
+}
+
+func newTypingTracker() *typingTracker {
+	return &typingTracker{active: make(map[typingKey]*typingState)}
+}
+
+// typingEvent adapts handleTyping to the inbound event registry.
+func (h *Hub) typingEvent(ctx context.Context, c *Client, msg Message, memberIDs []string) {
+	h.handleTyping(ctx, msg, memberIDs)
+}
+
+func (h *Hub) handleTyping(ctx context.Context, msg Message, memberIDs []string) {
+	key := typingKey{conversationID: msg.ConversationID, userID: msg.SenderID}
+
+	if msg.Type == "typing_stop" {
+		if h.typing.remove(key, nil) {
+			h.broadcastTyping(ctx, "typing_stop", key, msg.SenderName, memberIDs)
+		}
+		return
+	}
+
+	t := h.typing
+	t.mu.Lock()
+	state, ok := t.active[key]
+	if !ok {
+		state = &typingState{}
+		t.active[key] = state
+		state.timer = time.AfterFunc(typingTimeout, func() { h.expireTyping(key, state) })
+	} else {
+		state.timer.Reset(typingTimeout)
+	}
+	state.userName = msg.SenderName
+	state.memberIDs = memberIDs
+
+	throttled := time.Since(state.lastSent) < typingThrottle
+	if !throttled {
+		state.lastSent = time.Now()
+	}
+	t.mu.Unlock()
+
+	if !throttled {
+		h.broadcastTyping(ctx, "typing_start", key, msg.SenderName, memberIDs)
+	}
+}
+
+// expireTyping runs when a sender went quiet without sending typing_stop.
+func (h *Hub) expireTyping(key typingKey, state *typingState) {
+	if h.typing.remove(key, state) {
+		h.broadcastTyping(context.Background(), "typing_stop", key, state.userName, state.memberIDs)
+	}
+}
+
+// clearTyping drops any indicator the user has in the conversation without
+// telling anyone, e.g. because a real message from them just arrived.
+func (h *Hub) clearTyping(conversationID int64, userID string) {
+	h.typing.remove(typingKey{conversationID: conversationID, userID: userID}, nil)
+}
+
+// stopAllTyping ends every indicator of a user whose last session closed.
+func (h *Hub) stopAllTyping(userID string) {
+	t := h.typing
+	t.mu.Lock()
+	var stopped []typingKey
+	var states []*typingState
+	for key, state := range t.active {
+		if key.userID == userID {
+			state.timer.Stop()
+			delete(t.active, key)
+			stopped = append(stopped, key)
+			states = append(states, state)
+		}
+	}
+	t.mu.Unlock()
+
+	for i, key := range stopped {
+		h.broadcastTyping(context.Background(), "typing_stop", key, states[i].userName, states[i].memberIDs)
+	}
+}
+
+// remove deletes the indicator and reports whether it was active. When
+// expected is set, the entry is only removed if it is still that state.
+func (t *typingTracker) remove(key typingKey, expected *typingState) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	state, ok := t.active[key]
+	if !ok || (expected != nil && state != expected) {
+		return false
+	}
+	state.timer.Stop()
+	delete(t.active, key)
+	return true
+}
+
+func (h *Hub) broadcastTyping(ctx context.Context, eventType string, key typingKey, userName string, memberIDs []string) {
+	data, err := json.Marshal(TypingEvent{
+		Type:           eventType,
+		ConversationID: key.conversationID,
+		SenderID:       key.userID,
+		SenderName:     userName,
+	})
+	if err != nil {
+		log.Printf("Failed to encode %s for Conv %d: %v", eventType, key.conversationID, err)
+		return
+	}
+
+	frame := newOutboundFrame(data)
+	for _, memberID := range memberIDs {
+		if memberID != key.userID {
+			h.deliverFrame(ctx, memberID, frame)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestTypingIndicators(t *testing.T) {
+	type step struct {
+		action string
+		// want is the frame type bob receives, "" for none.
+		want string
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"start is broadcast", []step{{"start", "typing_start"}}},
+		{"refresh within throttle is dropped", []step{{"start", "typing_start"}, {"start", ""}}},
+		{"refresh after throttle is broadcast", []step{{"start", "typing_start"}, {"throttle elapsed", ""}, {"start", "typing_start"}}},
+		{"stop ends the indicator", []step{{"start", "typing_start"}, {"stop", "typing_stop"}, {"stop", ""}}},
+		{"stop without start", []step{{"stop", ""}}},
+		{"expiry sends stop", []step{{"start", "typing_start"}, {"expire", "typing_stop"}, {"stop", ""}}},
+		{"message clears silently", []step{{"start", "typing_start"}, {"message", ""}, {"expire", ""}, {"stop", ""}}},
+		{"stale timer after restart", []step{{"start", "typing_start"}, {"stop", "typing_stop"}, {"start", "typing_start"}, {"expire stale", ""}}},
+		{"disconnect stops every indicator", []step{{"start", "typing_start"}, {"disconnect", "typing_stop"}}},
+	}
+
+	ctx := context.Background()
+	key := typingKey{conversationID: 1, userID: "alice"}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHub(nil)
+			bob := newTestClient(h, "bob", "web")
+			h.clients["bob"] = map[string]*Client{bob.ID: bob}
+			members := []string{"alice", "bob"}
+			t.Cleanup(func() { h.stopAllTyping("alice") })
+
+			// The timers of the latest and the previous indicator.
+			var latest, previous *typingState
+			for i, s := range tt.steps {
+				switch s.action {
+				case "start", "stop":
+					h.handleTyping(ctx, Message{Type: "typing_" + s.action, ConversationID: 1, SenderID: "alice"}, members)
+				case "throttle elapsed":
+					h.typing.mu.Lock()
+					h.typing.active[key].lastSent = time.Now().Add(-typingThrottle)
+					h.typing.mu.Unlock()
+				case "expire":
+					h.expireTyping(key, latest)
+				case "expire stale":
+					h.expireTyping(key, previous)
+				case "message":
+					h.clearTyping(1, "alice")
+				case "disconnect":
+					h.stopAllTyping("alice")
+				}
+
+				h.typing.mu.Lock()
+				if state := h.typing.active[key]; state != nil && state != latest {
+					previous, latest = latest, state
+				}
+				h.typing.mu.Unlock()
+
+				got := ""
+				select {
+				case data := <-bob.Send:
+					var event TypingEvent
+					json.Unmarshal(data, &event)
+					got = event.Type
+				default:
+				}
+				if got != s.want {
+					t.Fatalf("step %d (%s): bob got %q, want %q", i, s.action, got, s.want)
+				}
+			}
+		})
+	}
+}
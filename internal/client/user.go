@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -11,6 +12,9 @@ import (
 	"time"
 )
 
+// ErrUserNotFound is returned when the user service does not know an ID.
+var ErrUserNotFound = errors.New("user not found")
+
 type UserInfo struct {
 	ID     string `json:"_id"`
 	Name   string `json:"name"`
@@ -112,6 +116,10 @@ func (c *UserClient) GetSingleUser(ctx context.Context, id string) (UserInfo, er
 	}
 	defer resp.Body.Close()
 
+	// Malformed IDs are rejected with 400, IDs of no user with 404.
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusBadRequest {
+		return UserInfo{}, ErrUserNotFound
+	}
 	if resp.StatusCode != http.StatusOK {
 		return UserInfo{}, fmt.Errorf("user service error: %d", resp.StatusCode)
 	}
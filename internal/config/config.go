@@ -9,6 +9,8 @@ import (
 
 type Config struct {
 	ServerPort                   string `mapstructure:"SERVER_PORT"`
+	AdminAddr                    string `mapstructure:"ADMIN_ADDR"`
+	NodeID                       string `mapstructure:"NODE_ID"`
 	DatabaseURL                  string `mapstructure:"DATABASE_URL"`
 	UserServiceURL               string `mapstructure:"USER_SERVICE_URL"`
 	KafkaBroker                  string `mapstructure:"KAFKA_BROKER"`
@@ -27,6 +29,12 @@ type Config struct {
 	LiveKitAPIKey                string `mapstructure:"LIVEKIT_API_KEY"`
 	LiveKitAPISecret             string `mapstructure:"LIVEKIT_API_SECRET"`
 	LiveKitURL                   string `mapstructure:"LIVEKIT_URL"`
+	CatchUpMaxMessages           int    `mapstructure:"CATCHUP_MAX_MESSAGES"`
+	MessageRecallWindowMinutes   int    `mapstructure:"MESSAGE_RECALL_WINDOW_MINUTES"`
+	WSDisableLegacyProtocol      bool   `mapstructure:"WS_DISABLE_LEGACY_PROTOCOL"`
+	WSRateLimits                 string `mapstructure:"WS_RATE_LIMITS"`
+	WSSendQueueSize              int    `mapstructure:"WS_SEND_QUEUE_SIZE"`
+	WSSlowConsumerPolicy         string `mapstructure:"WS_SLOW_CONSUMER_POLICY"`
 }
 
 var (
@@ -7,6 +7,7 @@ package db
 
 import (
 	"context"
+	"encoding/json"
 
 	"github.com/jackc/pgx/v5/pgtype"
 )
@@ -19,6 +20,7 @@ INSERT INTO participants (
 ) VALUES (
     $1, $2, $3
 )
+ON CONFLICT (conversation_id, user_id) DO NOTHING
 `
 
 type AddParticipantParams struct {
@@ -32,6 +34,51 @@ func (q *Queries) AddParticipant(ctx context.Context, arg AddParticipantParams)
 	return err
 }
 
+const countMessagesByFilePath = `-- name: CountMessagesByFilePath :one
+SELECT COUNT(*) FROM messages
+WHERE file_path = $1 AND is_deleted IS NOT TRUE
+`
+
+func (q *Queries) CountMessagesByFilePath(ctx context.Context, filePath pgtype.Text) (int64, error) {
+	row := q.db.QueryRow(ctx, countMessagesByFilePath, filePath)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countRepliesByParentIDs = `-- name: CountRepliesByParentIDs :many
+SELECT reply_to_id::bigint AS parent_id, COUNT(*) AS reply_count
+FROM messages
+WHERE reply_to_id = ANY($1::bigint[])
+  AND is_deleted IS NOT TRUE
+GROUP BY reply_to_id
+`
+
+type CountRepliesByParentIDsRow struct {
+	ParentID   int64 `json:"parent_id"`
+	ReplyCount int64 `json:"reply_count"`
+}
+
+func (q *Queries) CountRepliesByParentIDs(ctx context.Context, parentIds []int64) ([]CountRepliesByParentIDsRow, error) {
+	rows, err := q.db.Query(ctx, countRepliesByParentIDs, parentIds)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []CountRepliesByParentIDsRow
+	for rows.Next() {
+		var i CountRepliesByParentIDsRow
+		if err := rows.Scan(&i.ParentID, &i.ReplyCount); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const createConversation = `-- name: CreateConversation :one
 INSERT INTO conversations (
     name, 
@@ -75,24 +122,31 @@ INSERT INTO messages (
     file_type, 
     file_size,
     reply_to_id,
-    client_msg_id
+    client_msg_id,
+    mentions,
+    forwarded_from,
+    system_payload
 ) VALUES (
-    $1, $2, $3, $4, $5, $6, $7, $8, $9, $10
+    $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13
 )
-RETURNING id, conversation_id, sender_id, content, type, reply_to_id, is_deleted, created_at, file_name, file_id, file_path, file_type, file_size, client_msg_id
+ON CONFLICT (conversation_id, client_msg_id) DO NOTHING
+RETURNING id, conversation_id, sender_id, content, type, reply_to_id, is_deleted, created_at, file_name, file_id, file_path, file_type, file_size, client_msg_id, edited_at, mentions, forwarded_from, system_payload
 `
 
 type CreateMessageParams struct {
-	ConversationID int64       `json:"conversation_id"`
-	SenderID       string      `json:"sender_id"`
-	Content        pgtype.Text `json:"content"`
-	Type           pgtype.Text `json:"type"`
-	FileName       pgtype.Text `json:"file_name"`
-	FilePath       pgtype.Text `json:"file_path"`
-	FileType       pgtype.Text `json:"file_type"`
-	FileSize       pgtype.Int8 `json:"file_size"`
-	ReplyToID      pgtype.Int8 `json:"reply_to_id"`
-	ClientMsgID    pgtype.Text `json:"client_msg_id"`
+	ConversationID int64           `json:"conversation_id"`
+	SenderID       string          `json:"sender_id"`
+	Content        pgtype.Text     `json:"content"`
+	Type           pgtype.Text     `json:"type"`
+	FileName       pgtype.Text     `json:"file_name"`
+	FilePath       pgtype.Text     `json:"file_path"`
+	FileType       pgtype.Text     `json:"file_type"`
+	FileSize       pgtype.Int8     `json:"file_size"`
+	ReplyToID      pgtype.Int8     `json:"reply_to_id"`
+	ClientMsgID    pgtype.Text     `json:"client_msg_id"`
+	Mentions       json.RawMessage `json:"mentions"`
+	ForwardedFrom  pgtype.Int8     `json:"forwarded_from"`
+	SystemPayload  json.RawMessage `json:"system_payload"`
 }
 
 func (q *Queries) CreateMessage(ctx context.Context, arg CreateMessageParams) (Message, error) {
@@ -107,6 +161,86 @@ func (q *Queries) CreateMessage(ctx context.Context, arg CreateMessageParams) (M
 		arg.FileSize,
 		arg.ReplyToID,
 		arg.ClientMsgID,
+		arg.Mentions,
+		arg.ForwardedFrom,
+		arg.SystemPayload,
+	)
+	var i Message
+	err := row.Scan(
+		&i.ID,
+		&i.ConversationID,
+		&i.SenderID,
+		&i.Content,
+		&i.Type,
+		&i.ReplyToID,
+		&i.IsDeleted,
+		&i.CreatedAt,
+		&i.FileName,
+		&i.FileID,
+		&i.FilePath,
+		&i.FileType,
+		&i.FileSize,
+		&i.ClientMsgID,
+		&i.EditedAt,
+		&i.Mentions,
+		&i.ForwardedFrom,
+		&i.SystemPayload,
+	)
+	return i, err
+}
+
+const deleteFileCleanup = `-- name: DeleteFileCleanup :exec
+DELETE FROM file_cleanups WHERE id = $1
+`
+
+func (q *Queries) DeleteFileCleanup(ctx context.Context, id int64) error {
+	_, err := q.db.Exec(ctx, deleteFileCleanup, id)
+	return err
+}
+
+const deleteMessageEdits = `-- name: DeleteMessageEdits :exec
+DELETE FROM message_edits WHERE message_id = $1
+`
+
+func (q *Queries) DeleteMessageEdits(ctx context.Context, messageID int64) error {
+	_, err := q.db.Exec(ctx, deleteMessageEdits, messageID)
+	return err
+}
+
+const editMessage = `-- name: EditMessage :one
+WITH prev AS (
+    SELECT id, content FROM messages
+    WHERE id = $1
+      AND conversation_id = $2
+      AND sender_id = $3
+      AND is_deleted IS NOT TRUE
+      AND type IS DISTINCT FROM 'system'
+    FOR UPDATE
+), history AS (
+    INSERT INTO message_edits (message_id, content)
+    SELECT id, content FROM prev
+)
+UPDATE messages m
+SET content = $4,
+    edited_at = now()
+FROM prev
+WHERE m.id = prev.id
+RETURNING m.id, m.conversation_id, m.sender_id, m.content, m.type, m.reply_to_id, m.is_deleted, m.created_at, m.file_name, m.file_id, m.file_path, m.file_type, m.file_size, m.client_msg_id, m.edited_at, m.mentions, m.forwarded_from, m.system_payload
+`
+
+type EditMessageParams struct {
+	ID             int64       `json:"id"`
+	ConversationID int64       `json:"conversation_id"`
+	SenderID       string      `json:"sender_id"`
+	Content        pgtype.Text `json:"content"`
+}
+
+func (q *Queries) EditMessage(ctx context.Context, arg EditMessageParams) (Message, error) {
+	row := q.db.QueryRow(ctx, editMessage,
+		arg.ID,
+		arg.ConversationID,
+		arg.SenderID,
+		arg.Content,
 	)
 	var i Message
 	err := row.Scan(
@@ -124,6 +258,10 @@ func (q *Queries) CreateMessage(ctx context.Context, arg CreateMessageParams) (M
 		&i.FileType,
 		&i.FileSize,
 		&i.ClientMsgID,
+		&i.EditedAt,
+		&i.Mentions,
+		&i.ForwardedFrom,
+		&i.SystemPayload,
 	)
 	return i, err
 }
@@ -134,25 +272,27 @@ SELECT
     m.content as last_message_content,
     m.sender_id as last_message_sender_id,
     m.type as last_message_type,
-    m.file_name as last_message_file_name
+    m.file_name as last_message_file_name,
+    m.is_deleted as last_message_is_deleted
 FROM conversations c
 LEFT JOIN messages m ON c.last_message_id = m.id
 WHERE c.id = $1 LIMIT 1
 `
 
 type GetConversationByIDRow struct {
-	ID                  int64            `json:"id"`
-	Name                pgtype.Text      `json:"name"`
-	Avatar              pgtype.Text      `json:"avatar"`
-	IsGroup             pgtype.Bool      `json:"is_group"`
-	LastMessageID       pgtype.Int8      `json:"last_message_id"`
-	LastMessageAt       pgtype.Timestamp `json:"last_message_at"`
-	CreatedAt           pgtype.Timestamp `json:"created_at"`
-	UpdatedAt           pgtype.Timestamp `json:"updated_at"`
-	LastMessageContent  pgtype.Text      `json:"last_message_content"`
-	LastMessageSenderID pgtype.Text      `json:"last_message_sender_id"`
-	LastMessageType     pgtype.Text      `json:"last_message_type"`
-	LastMessageFileName pgtype.Text      `json:"last_message_file_name"`
+	ID                   int64            `json:"id"`
+	Name                 pgtype.Text      `json:"name"`
+	Avatar               pgtype.Text      `json:"avatar"`
+	IsGroup              pgtype.Bool      `json:"is_group"`
+	LastMessageID        pgtype.Int8      `json:"last_message_id"`
+	LastMessageAt        pgtype.Timestamp `json:"last_message_at"`
+	CreatedAt            pgtype.Timestamp `json:"created_at"`
+	UpdatedAt            pgtype.Timestamp `json:"updated_at"`
+	LastMessageContent   pgtype.Text      `json:"last_message_content"`
+	LastMessageSenderID  pgtype.Text      `json:"last_message_sender_id"`
+	LastMessageType      pgtype.Text      `json:"last_message_type"`
+	LastMessageFileName  pgtype.Text      `json:"last_message_file_name"`
+	LastMessageIsDeleted pgtype.Bool      `json:"last_message_is_deleted"`
 }
 
 func (q *Queries) GetConversationByID(ctx context.Context, id int64) (GetConversationByIDRow, error) {
@@ -171,26 +311,111 @@ func (q *Queries) GetConversationByID(ctx context.Context, id int64) (GetConvers
 		&i.LastMessageSenderID,
 		&i.LastMessageType,
 		&i.LastMessageFileName,
+		&i.LastMessageIsDeleted,
+	)
+	return i, err
+}
+
+const getMessageByClientMsgID = `-- name: GetMessageByClientMsgID :one
+SELECT id, conversation_id, sender_id, content, type, reply_to_id, is_deleted, created_at, file_name, file_id, file_path, file_type, file_size, client_msg_id, edited_at, mentions, forwarded_from, system_payload FROM messages
+WHERE conversation_id = $1 AND client_msg_id = $2
+LIMIT 1
+`
+
+type GetMessageByClientMsgIDParams struct {
+	ConversationID int64       `json:"conversation_id"`
+	ClientMsgID    pgtype.Text `json:"client_msg_id"`
+}
+
+func (q *Queries) GetMessageByClientMsgID(ctx context.Context, arg GetMessageByClientMsgIDParams) (Message, error) {
+	row := q.db.QueryRow(ctx, getMessageByClientMsgID, arg.ConversationID, arg.ClientMsgID)
+	var i Message
+	err := row.Scan(
+		&i.ID,
+		&i.ConversationID,
+		&i.SenderID,
+		&i.Content,
+		&i.Type,
+		&i.ReplyToID,
+		&i.IsDeleted,
+		&i.CreatedAt,
+		&i.FileName,
+		&i.FileID,
+		&i.FilePath,
+		&i.FileType,
+		&i.FileSize,
+		&i.ClientMsgID,
+		&i.EditedAt,
+		&i.Mentions,
+		&i.ForwardedFrom,
+		&i.SystemPayload,
+	)
+	return i, err
+}
+
+const getMessageByID = `-- name: GetMessageByID :one
+SELECT id, conversation_id, sender_id, content, type, reply_to_id, is_deleted, created_at, file_name, file_id, file_path, file_type, file_size, client_msg_id, edited_at, mentions, forwarded_from, system_payload FROM messages
+WHERE id = $1 AND conversation_id = $2
+LIMIT 1
+`
+
+type GetMessageByIDParams struct {
+	ID             int64 `json:"id"`
+	ConversationID int64 `json:"conversation_id"`
+}
+
+func (q *Queries) GetMessageByID(ctx context.Context, arg GetMessageByIDParams) (Message, error) {
+	row := q.db.QueryRow(ctx, getMessageByID, arg.ID, arg.ConversationID)
+	var i Message
+	err := row.Scan(
+		&i.ID,
+		&i.ConversationID,
+		&i.SenderID,
+		&i.Content,
+		&i.Type,
+		&i.ReplyToID,
+		&i.IsDeleted,
+		&i.CreatedAt,
+		&i.FileName,
+		&i.FileID,
+		&i.FilePath,
+		&i.FileType,
+		&i.FileSize,
+		&i.ClientMsgID,
+		&i.EditedAt,
+		&i.Mentions,
+		&i.ForwardedFrom,
+		&i.SystemPayload,
 	)
 	return i, err
 }
 
 const getMessagesByConversation = `-- name: GetMessagesByConversation :many
-SELECT id, conversation_id, sender_id, content, type, reply_to_id, is_deleted, created_at, file_name, file_id, file_path, file_type, file_size, client_msg_id FROM messages
+SELECT id, conversation_id, sender_id, content, type, reply_to_id, is_deleted, created_at, file_name, file_id, file_path, file_type, file_size, client_msg_id, edited_at, mentions, forwarded_from, system_payload FROM messages
 WHERE conversation_id = $1
 AND ($2::bigint = 0 OR id < $2)
+AND NOT EXISTS (
+    SELECT 1 FROM hidden_messages h
+    WHERE h.message_id = messages.id AND h.user_id = $3
+)
 ORDER BY id DESC
-LIMIT $3
+LIMIT $4
 `
 
 type GetMessagesByConversationParams struct {
-	ConversationID int64 `json:"conversation_id"`
-	BeforeID       int64 `json:"before_id"`
-	LimitCount     int32 `json:"limit_count"`
+	ConversationID int64  `json:"conversation_id"`
+	BeforeID       int64  `json:"before_id"`
+	UserID         string `json:"user_id"`
+	LimitCount     int32  `json:"limit_count"`
 }
 
 func (q *Queries) GetMessagesByConversation(ctx context.Context, arg GetMessagesByConversationParams) ([]Message, error) {
-	rows, err := q.db.Query(ctx, getMessagesByConversation, arg.ConversationID, arg.BeforeID, arg.LimitCount)
+	rows, err := q.db.Query(ctx, getMessagesByConversation,
+		arg.ConversationID,
+		arg.BeforeID,
+		arg.UserID,
+		arg.LimitCount,
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -213,6 +438,10 @@ func (q *Queries) GetMessagesByConversation(ctx context.Context, arg GetMessages
 			&i.FileType,
 			&i.FileSize,
 			&i.ClientMsgID,
+			&i.EditedAt,
+			&i.Mentions,
+			&i.ForwardedFrom,
+			&i.SystemPayload,
 		); err != nil {
 			return nil, err
 		}
@@ -254,6 +483,15 @@ INNER JOIN participants p ON m.conversation_id = p.conversation_id
 WHERE p.user_id = $1 
   AND m.id > COALESCE(p.last_read_message_id, 0)
   AND m.sender_id != $1
+  AND m.is_deleted IS NOT TRUE
+  AND m.type IS DISTINCT FROM 'system'
+  -- Muted conversations only count messages that mention the user.
+  AND (
+      p.muted_until IS NULL
+      OR p.muted_until <= now()
+      OR m.mentions @> jsonb_build_array(jsonb_build_object('user_id', $1::text))
+      OR m.mentions @> '[{"user_id": "all"}]'
+  )
 `
 
 func (q *Queries) GetTotalUnreadCount(ctx context.Context, userID string) (int64, error) {
@@ -263,6 +501,125 @@ func (q *Queries) GetTotalUnreadCount(ctx context.Context, userID string) (int64
 	return count, err
 }
 
+const getUploadOwner = `-- name: GetUploadOwner :one
+SELECT user_id FROM uploads
+WHERE file_path = $1
+`
+
+func (q *Queries) GetUploadOwner(ctx context.Context, filePath string) (string, error) {
+	row := q.db.QueryRow(ctx, getUploadOwner, filePath)
+	var user_id string
+	err := row.Scan(&user_id)
+	return user_id, err
+}
+
+const hideMessage = `-- name: HideMessage :exec
+INSERT INTO hidden_messages (user_id, message_id)
+VALUES ($1, $2)
+ON CONFLICT DO NOTHING
+`
+
+type HideMessageParams struct {
+	UserID    string `json:"user_id"`
+	MessageID int64  `json:"message_id"`
+}
+
+func (q *Queries) HideMessage(ctx context.Context, arg HideMessageParams) error {
+	_, err := q.db.Exec(ctx, hideMessage, arg.UserID, arg.MessageID)
+	return err
+}
+
+const listConversationMessagesSince = `-- name: ListConversationMessagesSince :many
+SELECT id, conversation_id, sender_id, content, type, reply_to_id, is_deleted, created_at, file_name, file_id, file_path, file_type, file_size, client_msg_id, edited_at, mentions, forwarded_from, system_payload FROM messages
+WHERE conversation_id = $1
+  AND id > $2::bigint
+  AND NOT EXISTS (
+      SELECT 1 FROM hidden_messages h
+      WHERE h.message_id = messages.id AND h.user_id = $3
+  )
+ORDER BY id ASC
+LIMIT $4
+`
+
+type ListConversationMessagesSinceParams struct {
+	ConversationID int64  `json:"conversation_id"`
+	AfterID        int64  `json:"after_id"`
+	UserID         string `json:"user_id"`
+	LimitCount     int32  `json:"limit_count"`
+}
+
+func (q *Queries) ListConversationMessagesSince(ctx context.Context, arg ListConversationMessagesSinceParams) ([]Message, error) {
+	rows, err := q.db.Query(ctx, listConversationMessagesSince,
+		arg.ConversationID,
+		arg.AfterID,
+		arg.UserID,
+		arg.LimitCount,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Message
+	for rows.Next() {
+		var i Message
+		if err := rows.Scan(
+			&i.ID,
+			&i.ConversationID,
+			&i.SenderID,
+			&i.Content,
+			&i.Type,
+			&i.ReplyToID,
+			&i.IsDeleted,
+			&i.CreatedAt,
+			&i.FileName,
+			&i.FileID,
+			&i.FilePath,
+			&i.FileType,
+			&i.FileSize,
+			&i.ClientMsgID,
+			&i.EditedAt,
+			&i.Mentions,
+			&i.ForwardedFrom,
+			&i.SystemPayload,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listConversationPartnerIDs = `-- name: ListConversationPartnerIDs :many
+SELECT DISTINCT p2.user_id
+FROM participants p1
+JOIN participants p2 ON p1.conversation_id = p2.conversation_id
+WHERE p1.user_id = $1
+  AND p2.user_id != $1
+`
+
+func (q *Queries) ListConversationPartnerIDs(ctx context.Context, userID string) ([]string, error) {
+	rows, err := q.db.Query(ctx, listConversationPartnerIDs, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var user_id string
+		if err := rows.Scan(&user_id); err != nil {
+			return nil, err
+		}
+		items = append(items, user_id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const listConversationsByUser = `-- name: ListConversationsByUser :many
 SELECT 
     c.id, 
@@ -275,14 +632,32 @@ SELECT
     m.sender_id as last_message_sender_id,
     m.type as last_message_type,
     m.file_name as last_message_file_name,
+    m.is_deleted as last_message_is_deleted,
     p.last_read_message_id,
+    p.muted_until,
+    p.is_archived,
+    p.pinned_at,
     (
         SELECT COUNT(m2.id) 
         FROM messages m2 
         WHERE m2.conversation_id = c.id 
           AND m2.id > COALESCE(p.last_read_message_id, 0)
           AND m2.sender_id != $1
+          AND m2.is_deleted IS NOT TRUE
+          AND m2.type IS DISTINCT FROM 'system'
     ) as unread_count,
+    (
+        SELECT COUNT(m3.id)
+        FROM messages m3
+        WHERE m3.conversation_id = c.id
+          AND m3.id > COALESCE(p.last_read_message_id, 0)
+          AND m3.sender_id != $1
+          AND m3.is_deleted IS NOT TRUE
+          AND (
+              m3.mentions @> jsonb_build_array(jsonb_build_object('user_id', $1::text))
+              OR m3.mentions @> '[{"user_id": "all"}]'
+          )
+    ) as unread_mention_count,
     (
         SELECT ARRAY_AGG(user_id)::TEXT[] 
         FROM participants 
@@ -290,36 +665,61 @@ SELECT
     ) as participant_ids
 FROM conversations c
 JOIN participants p ON c.id = p.conversation_id
-LEFT JOIN messages m ON c.last_message_id = m.id
+-- The preview is the newest message the user has not deleted for themselves.
+LEFT JOIN LATERAL (
+    SELECT lm.content, lm.sender_id, lm.type, lm.file_name, lm.is_deleted
+    FROM messages lm
+    WHERE lm.conversation_id = c.id
+      AND lm.id <= c.last_message_id
+      AND NOT EXISTS (
+          SELECT 1 FROM hidden_messages h
+          WHERE h.message_id = lm.id AND h.user_id = $1
+      )
+    ORDER BY lm.id DESC
+    LIMIT 1
+) m ON TRUE
 WHERE p.user_id = $1
-ORDER BY c.last_message_at DESC
+  AND p.is_archived = $4
+-- Pinned conversations come first, most recently pinned on top.
+ORDER BY p.pinned_at DESC NULLS LAST, c.last_message_at DESC
 LIMIT $2 OFFSET $3
 `
 
 type ListConversationsByUserParams struct {
-	SenderID string `json:"sender_id"`
-	Limit    int32  `json:"limit"`
-	Offset   int32  `json:"offset"`
+	SenderID   string `json:"sender_id"`
+	Limit      int32  `json:"limit"`
+	Offset     int32  `json:"offset"`
+	IsArchived bool   `json:"is_archived"`
 }
 
 type ListConversationsByUserRow struct {
-	ID                  int64            `json:"id"`
-	Name                pgtype.Text      `json:"name"`
-	Avatar              pgtype.Text      `json:"avatar"`
-	IsGroup             pgtype.Bool      `json:"is_group"`
-	LastMessageID       pgtype.Int8      `json:"last_message_id"`
-	LastMessageAt       pgtype.Timestamp `json:"last_message_at"`
-	LastMessageContent  pgtype.Text      `json:"last_message_content"`
-	LastMessageSenderID pgtype.Text      `json:"last_message_sender_id"`
-	LastMessageType     pgtype.Text      `json:"last_message_type"`
-	LastMessageFileName pgtype.Text      `json:"last_message_file_name"`
-	LastReadMessageID   pgtype.Int8      `json:"last_read_message_id"`
-	UnreadCount         int64            `json:"unread_count"`
-	ParticipantIds      []string         `json:"participant_ids"`
+	ID                   int64              `json:"id"`
+	Name                 pgtype.Text        `json:"name"`
+	Avatar               pgtype.Text        `json:"avatar"`
+	IsGroup              pgtype.Bool        `json:"is_group"`
+	LastMessageID        pgtype.Int8        `json:"last_message_id"`
+	LastMessageAt        pgtype.Timestamp   `json:"last_message_at"`
+	LastMessageContent   pgtype.Text        `json:"last_message_content"`
+	LastMessageSenderID  pgtype.Text        `json:"last_message_sender_id"`
+	LastMessageType      pgtype.Text        `json:"last_message_type"`
+	LastMessageFileName  pgtype.Text        `json:"last_message_file_name"`
+	LastMessageIsDeleted pgtype.Bool        `json:"last_message_is_deleted"`
+	LastReadMessageID    pgtype.Int8        `json:"last_read_message_id"`
+	MutedUntil           pgtype.Timestamptz `json:"muted_until"`
+	IsArchived           bool               `json:"is_archived"`
+	PinnedAt             pgtype.Timestamptz `json:"pinned_at"`
+	UnreadCount          int64              `json:"unread_count"`
+	UnreadMentionCount   int64              `json:"unread_mention_count"`
+	ParticipantIds       []string           `json:"participant_ids"`
 }
 
 func (q *Queries) ListConversationsByUser(ctx context.Context, arg ListConversationsByUserParams) ([]ListConversationsByUserRow, error) {
-	rows, err := q.db.Query(ctx, listConversationsByUser, arg.SenderID, arg.Limit, arg.Offset)
+	rows, err := q.db.Query(ctx, listConversationsByUser,
+		arg.SenderID,
+		arg.Limit,
+		arg.Offset,
+		arg.IsArchived,
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -338,8 +738,13 @@ func (q *Queries) ListConversationsByUser(ctx context.Context, arg ListConversat
 			&i.LastMessageSenderID,
 			&i.LastMessageType,
 			&i.LastMessageFileName,
+			&i.LastMessageIsDeleted,
 			&i.LastReadMessageID,
+			&i.MutedUntil,
+			&i.IsArchived,
+			&i.PinnedAt,
 			&i.UnreadCount,
+			&i.UnreadMentionCount,
 			&i.ParticipantIds,
 		); err != nil {
 			return nil, err
@@ -352,17 +757,191 @@ func (q *Queries) ListConversationsByUser(ctx context.Context, arg ListConversat
 	return items, nil
 }
 
+const listDueFileCleanups = `-- name: ListDueFileCleanups :many
+SELECT id, file_path, run_after, created_at FROM file_cleanups
+WHERE run_after <= now()
+ORDER BY run_after
+LIMIT $1
+`
+
+func (q *Queries) ListDueFileCleanups(ctx context.Context, limit int32) ([]FileCleanup, error) {
+	rows, err := q.db.Query(ctx, listDueFileCleanups, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []FileCleanup
+	for rows.Next() {
+		var i FileCleanup
+		if err := rows.Scan(
+			&i.ID,
+			&i.FilePath,
+			&i.RunAfter,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listMessageEdits = `-- name: ListMessageEdits :many
+SELECT e.id, e.message_id, e.content, e.edited_at FROM message_edits e
+JOIN messages m ON m.id = e.message_id
+WHERE e.message_id = $1
+  AND m.conversation_id = $2
+  AND m.is_deleted IS NOT TRUE
+ORDER BY e.edited_at DESC
+`
+
+type ListMessageEditsParams struct {
+	MessageID      int64 `json:"message_id"`
+	ConversationID int64 `json:"conversation_id"`
+}
+
+func (q *Queries) ListMessageEdits(ctx context.Context, arg ListMessageEditsParams) ([]MessageEdit, error) {
+	rows, err := q.db.Query(ctx, listMessageEdits, arg.MessageID, arg.ConversationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []MessageEdit
+	for rows.Next() {
+		var i MessageEdit
+		if err := rows.Scan(
+			&i.ID,
+			&i.MessageID,
+			&i.Content,
+			&i.EditedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listMessagesByIDs = `-- name: ListMessagesByIDs :many
+SELECT id, conversation_id, sender_id, content, type, reply_to_id, is_deleted, created_at, file_name, file_id, file_path, file_type, file_size, client_msg_id, edited_at, mentions, forwarded_from, system_payload FROM messages
+WHERE id = ANY($1::bigint[])
+`
+
+func (q *Queries) ListMessagesByIDs(ctx context.Context, ids []int64) ([]Message, error) {
+	rows, err := q.db.Query(ctx, listMessagesByIDs, ids)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Message
+	for rows.Next() {
+		var i Message
+		if err := rows.Scan(
+			&i.ID,
+			&i.ConversationID,
+			&i.SenderID,
+			&i.Content,
+			&i.Type,
+			&i.ReplyToID,
+			&i.IsDeleted,
+			&i.CreatedAt,
+			&i.FileName,
+			&i.FileID,
+			&i.FilePath,
+			&i.FileType,
+			&i.FileSize,
+			&i.ClientMsgID,
+			&i.EditedAt,
+			&i.Mentions,
+			&i.ForwardedFrom,
+			&i.SystemPayload,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listMessagesSince = `-- name: ListMessagesSince :many
+SELECT m.id, m.conversation_id, m.sender_id, m.content, m.type, m.reply_to_id, m.is_deleted, m.created_at, m.file_name, m.file_id, m.file_path, m.file_type, m.file_size, m.client_msg_id, m.edited_at, m.mentions, m.forwarded_from, m.system_payload FROM messages m
+JOIN participants p ON p.conversation_id = m.conversation_id
+WHERE p.user_id = $1
+  AND m.id > $2::bigint
+  AND NOT EXISTS (
+      SELECT 1 FROM hidden_messages h
+      WHERE h.message_id = m.id AND h.user_id = $1
+  )
+ORDER BY m.id ASC
+LIMIT $3
+`
+
+type ListMessagesSinceParams struct {
+	UserID     string `json:"user_id"`
+	AfterID    int64  `json:"after_id"`
+	LimitCount int32  `json:"limit_count"`
+}
+
+func (q *Queries) ListMessagesSince(ctx context.Context, arg ListMessagesSinceParams) ([]Message, error) {
+	rows, err := q.db.Query(ctx, listMessagesSince, arg.UserID, arg.AfterID, arg.LimitCount)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Message
+	for rows.Next() {
+		var i Message
+		if err := rows.Scan(
+			&i.ID,
+			&i.ConversationID,
+			&i.SenderID,
+			&i.Content,
+			&i.Type,
+			&i.ReplyToID,
+			&i.IsDeleted,
+			&i.CreatedAt,
+			&i.FileName,
+			&i.FileID,
+			&i.FilePath,
+			&i.FileType,
+			&i.FileSize,
+			&i.ClientMsgID,
+			&i.EditedAt,
+			&i.Mentions,
+			&i.ForwardedFrom,
+			&i.SystemPayload,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const listParticipantsByConversation = `-- name: ListParticipantsByConversation :many
-SELECT user_id, role, joined_at, last_read_message_id
+SELECT user_id, role, joined_at, last_read_message_id, last_delivered_message_id
 FROM participants 
 WHERE conversation_id = $1
 `
 
 type ListParticipantsByConversationRow struct {
-	UserID            string           `json:"user_id"`
-	Role              pgtype.Text      `json:"role"`
-	JoinedAt          pgtype.Timestamp `json:"joined_at"`
-	LastReadMessageID pgtype.Int8      `json:"last_read_message_id"`
+	UserID                 string           `json:"user_id"`
+	Role                   pgtype.Text      `json:"role"`
+	JoinedAt               pgtype.Timestamp `json:"joined_at"`
+	LastReadMessageID      pgtype.Int8      `json:"last_read_message_id"`
+	LastDeliveredMessageID pgtype.Int8      `json:"last_delivered_message_id"`
 }
 
 func (q *Queries) ListParticipantsByConversation(ctx context.Context, conversationID int64) ([]ListParticipantsByConversationRow, error) {
@@ -379,6 +958,52 @@ func (q *Queries) ListParticipantsByConversation(ctx context.Context, conversati
 			&i.Role,
 			&i.JoinedAt,
 			&i.LastReadMessageID,
+			&i.LastDeliveredMessageID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listReadStatesSince = `-- name: ListReadStatesSince :many
+SELECT p2.conversation_id, p2.user_id, p2.last_read_message_id, p2.last_read_at
+FROM participants p1
+JOIN participants p2 ON p2.conversation_id = p1.conversation_id
+WHERE p1.user_id = $1
+  AND p2.last_read_message_id > $2::bigint
+`
+
+type ListReadStatesSinceParams struct {
+	UserID  string `json:"user_id"`
+	AfterID int64  `json:"after_id"`
+}
+
+type ListReadStatesSinceRow struct {
+	ConversationID    int64              `json:"conversation_id"`
+	UserID            string             `json:"user_id"`
+	LastReadMessageID pgtype.Int8        `json:"last_read_message_id"`
+	LastReadAt        pgtype.Timestamptz `json:"last_read_at"`
+}
+
+func (q *Queries) ListReadStatesSince(ctx context.Context, arg ListReadStatesSinceParams) ([]ListReadStatesSinceRow, error) {
+	rows, err := q.db.Query(ctx, listReadStatesSince, arg.UserID, arg.AfterID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListReadStatesSinceRow
+	for rows.Next() {
+		var i ListReadStatesSinceRow
+		if err := rows.Scan(
+			&i.ConversationID,
+			&i.UserID,
+			&i.LastReadMessageID,
+			&i.LastReadAt,
 		); err != nil {
 			return nil, err
 		}
@@ -390,10 +1015,93 @@ func (q *Queries) ListParticipantsByConversation(ctx context.Context, conversati
 	return items, nil
 }
 
-const markMessageAsRead = `-- name: MarkMessageAsRead :exec
+const listThreadReplies = `-- name: ListThreadReplies :many
+SELECT id, conversation_id, sender_id, content, type, reply_to_id, is_deleted, created_at, file_name, file_id, file_path, file_type, file_size, client_msg_id, edited_at, mentions, forwarded_from, system_payload FROM messages
+WHERE conversation_id = $1
+  AND reply_to_id = $2
+  AND id > $3::bigint
+  AND NOT EXISTS (
+      SELECT 1 FROM hidden_messages h
+      WHERE h.message_id = messages.id AND h.user_id = $4
+  )
+ORDER BY id ASC
+LIMIT $5
+`
+
+type ListThreadRepliesParams struct {
+	ConversationID int64       `json:"conversation_id"`
+	ParentID       pgtype.Int8 `json:"parent_id"`
+	AfterID        int64       `json:"after_id"`
+	UserID         string      `json:"user_id"`
+	LimitCount     int32       `json:"limit_count"`
+}
+
+func (q *Queries) ListThreadReplies(ctx context.Context, arg ListThreadRepliesParams) ([]Message, error) {
+	rows, err := q.db.Query(ctx, listThreadReplies,
+		arg.ConversationID,
+		arg.ParentID,
+		arg.AfterID,
+		arg.UserID,
+		arg.LimitCount,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Message
+	for rows.Next() {
+		var i Message
+		if err := rows.Scan(
+			&i.ID,
+			&i.ConversationID,
+			&i.SenderID,
+			&i.Content,
+			&i.Type,
+			&i.ReplyToID,
+			&i.IsDeleted,
+			&i.CreatedAt,
+			&i.FileName,
+			&i.FileID,
+			&i.FilePath,
+			&i.FileType,
+			&i.FileSize,
+			&i.ClientMsgID,
+			&i.EditedAt,
+			&i.Mentions,
+			&i.ForwardedFrom,
+			&i.SystemPayload,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const lockConversation = `-- name: LockConversation :exec
+SELECT id FROM conversations
+WHERE id = $1
+FOR NO KEY UPDATE
+`
+
+// Serializes changes that must check and update a conversation atomically,
+// such as its pins or its admins, without blocking new messages.
+func (q *Queries) LockConversation(ctx context.Context, id int64) error {
+	_, err := q.db.Exec(ctx, lockConversation, id)
+	return err
+}
+
+const markMessageAsRead = `-- name: MarkMessageAsRead :one
 UPDATE participants
-SET last_read_message_id = $3
+SET last_read_message_id = $3,
+    last_read_at = now(),
+    last_delivered_message_id = GREATEST(COALESCE(last_delivered_message_id, 0), $3)
 WHERE conversation_id = $1 AND user_id = $2
+  AND COALESCE(last_read_message_id, 0) < $3
+RETURNING last_read_at
 `
 
 type MarkMessageAsReadParams struct {
@@ -402,8 +1110,74 @@ type MarkMessageAsReadParams struct {
 	LastReadMessageID pgtype.Int8 `json:"last_read_message_id"`
 }
 
-func (q *Queries) MarkMessageAsRead(ctx context.Context, arg MarkMessageAsReadParams) error {
-	_, err := q.db.Exec(ctx, markMessageAsRead, arg.ConversationID, arg.UserID, arg.LastReadMessageID)
+// Read positions only move forward; no row is returned otherwise. A read
+// message has obviously been delivered too.
+func (q *Queries) MarkMessageAsRead(ctx context.Context, arg MarkMessageAsReadParams) (pgtype.Timestamptz, error) {
+	row := q.db.QueryRow(ctx, markMessageAsRead, arg.ConversationID, arg.UserID, arg.LastReadMessageID)
+	var last_read_at pgtype.Timestamptz
+	err := row.Scan(&last_read_at)
+	return last_read_at, err
+}
+
+const recallMessage = `-- name: RecallMessage :one
+UPDATE messages
+SET is_deleted = TRUE,
+    content = NULL,
+    file_name = NULL,
+    file_id = NULL,
+    file_path = NULL,
+    file_type = NULL,
+    file_size = NULL,
+    mentions = NULL
+WHERE id = $1
+  AND conversation_id = $2
+  AND is_deleted IS NOT TRUE
+RETURNING id, conversation_id, sender_id, content, type, reply_to_id, is_deleted, created_at, file_name, file_id, file_path, file_type, file_size, client_msg_id, edited_at, mentions, forwarded_from, system_payload
+`
+
+type RecallMessageParams struct {
+	ID             int64 `json:"id"`
+	ConversationID int64 `json:"conversation_id"`
+}
+
+func (q *Queries) RecallMessage(ctx context.Context, arg RecallMessageParams) (Message, error) {
+	row := q.db.QueryRow(ctx, recallMessage, arg.ID, arg.ConversationID)
+	var i Message
+	err := row.Scan(
+		&i.ID,
+		&i.ConversationID,
+		&i.SenderID,
+		&i.Content,
+		&i.Type,
+		&i.ReplyToID,
+		&i.IsDeleted,
+		&i.CreatedAt,
+		&i.FileName,
+		&i.FileID,
+		&i.FilePath,
+		&i.FileType,
+		&i.FileSize,
+		&i.ClientMsgID,
+		&i.EditedAt,
+		&i.Mentions,
+		&i.ForwardedFrom,
+		&i.SystemPayload,
+	)
+	return i, err
+}
+
+const recordUpload = `-- name: RecordUpload :exec
+INSERT INTO uploads (file_path, user_id)
+VALUES ($1, $2)
+`
+
+type RecordUploadParams struct {
+	FilePath string `json:"file_path"`
+	UserID   string `json:"user_id"`
+}
+
+func (q *Queries) RecordUpload(ctx context.Context, arg RecordUploadParams) error {
+	_, err := q.db.Exec(ctx, recordUpload, arg.FilePath, arg.UserID)
 	return err
 }
 
@@ -422,6 +1196,21 @@ func (q *Queries) RemoveParticipant(ctx context.Context, arg RemoveParticipantPa
 	return err
 }
 
+const scheduleFileCleanup = `-- name: ScheduleFileCleanup :exec
+INSERT INTO file_cleanups (file_path, run_after)
+VALUES ($1, $2)
+`
+
+type ScheduleFileCleanupParams struct {
+	FilePath string             `json:"file_path"`
+	RunAfter pgtype.Timestamptz `json:"run_after"`
+}
+
+func (q *Queries) ScheduleFileCleanup(ctx context.Context, arg ScheduleFileCleanupParams) error {
+	_, err := q.db.Exec(ctx, scheduleFileCleanup, arg.FilePath, arg.RunAfter)
+	return err
+}
+
 const updateConversationInfo = `-- name: UpdateConversationInfo :exec
 UPDATE conversations
 SET 
@@ -449,6 +1238,7 @@ SET
     last_message_at = $3,
     updated_at = now()
 WHERE id = $1
+  AND (last_message_id IS NULL OR last_message_id < $2)
 `
 
 type UpdateConversationLastMessageParams struct {
@@ -478,3 +1268,20 @@ func (q *Queries) UpdateLastReadMessage(ctx context.Context, arg UpdateLastReadM
 	_, err := q.db.Exec(ctx, updateLastReadMessage, arg.ConversationID, arg.UserID, arg.LastReadMessageID)
 	return err
 }
+
+const updateParticipantRole = `-- name: UpdateParticipantRole :exec
+UPDATE participants
+SET role = $3
+WHERE conversation_id = $1 AND user_id = $2
+`
+
+type UpdateParticipantRoleParams struct {
+	ConversationID int64       `json:"conversation_id"`
+	UserID         string      `json:"user_id"`
+	Role           pgtype.Text `json:"role"`
+}
+
+func (q *Queries) UpdateParticipantRole(ctx context.Context, arg UpdateParticipantRoleParams) error {
+	_, err := q.db.Exec(ctx, updateParticipantRole, arg.ConversationID, arg.UserID, arg.Role)
+	return err
+}
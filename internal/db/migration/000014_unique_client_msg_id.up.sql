@@ -0,0 +1,18 @@
+-- Point conversations at the first copy of any duplicated message before removing the rest
+UPDATE conversations c
+SET last_message_id = keep.id
+FROM messages dup
+JOIN messages keep
+  ON keep.conversation_id = dup.conversation_id
+ AND keep.client_msg_id = dup.client_msg_id
+ AND keep.id < dup.id
+WHERE c.last_message_id = dup.id;
+
+DELETE FROM messages dup
+USING messages keep
+WHERE dup.conversation_id = keep.conversation_id
+  AND dup.client_msg_id = keep.client_msg_id
+  AND dup.id > keep.id;
+
+ALTER TABLE messages
+ADD CONSTRAINT uq_messages_conversation_client_msg UNIQUE (conversation_id, client_msg_id);
@@ -0,0 +1,12 @@
+ALTER TABLE messages ADD COLUMN edited_at TIMESTAMPTZ;
+
+CREATE TABLE IF NOT EXISTS message_edits (
+    id BIGSERIAL PRIMARY KEY,
+    message_id BIGINT NOT NULL,
+    content TEXT,
+    edited_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+    CONSTRAINT fk_edit_message FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_message_edits_message ON message_edits(message_id, edited_at DESC);
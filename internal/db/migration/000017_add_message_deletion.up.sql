@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS hidden_messages (
+    user_id VARCHAR(25) NOT NULL,
+    message_id BIGINT NOT NULL,
+    hidden_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+    PRIMARY KEY (user_id, message_id),
+    CONSTRAINT fk_hidden_message FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS file_cleanups (
+    id BIGSERIAL PRIMARY KEY,
+    file_path TEXT NOT NULL,
+    run_after TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- Who uploaded each object, so only the uploader can attach it to a message.
+CREATE TABLE IF NOT EXISTS uploads (
+    file_path TEXT PRIMARY KEY,
+    user_id VARCHAR(25) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_file_cleanups_run_after ON file_cleanups(run_after);
+CREATE INDEX IF NOT EXISTS idx_messages_conversation_id ON messages(conversation_id, id DESC);
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS pinned_messages (
+    conversation_id BIGINT NOT NULL,
+    message_id BIGINT NOT NULL,
+    pinned_by VARCHAR(25) NOT NULL,
+    pinned_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+    PRIMARY KEY (conversation_id, message_id),
+    CONSTRAINT fk_pin_conversation FOREIGN KEY (conversation_id) REFERENCES conversations(id) ON DELETE CASCADE,
+    CONSTRAINT fk_pin_message FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE
+);
@@ -0,0 +1,17 @@
+CREATE EXTENSION IF NOT EXISTS unaccent;
+
+-- "simple" keeps every word as typed, which suits Vietnamese better than a
+-- stemming config; unaccent lets "tieng viet" match "tiếng việt".
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_ts_config WHERE cfgname = 'chat_search') THEN
+        CREATE TEXT SEARCH CONFIGURATION chat_search (COPY = simple);
+        ALTER TEXT SEARCH CONFIGURATION chat_search
+            ALTER MAPPING FOR hword, hword_part, word WITH unaccent, simple;
+    END IF;
+END
+$$;
+
+CREATE INDEX IF NOT EXISTS idx_messages_search ON messages USING GIN (
+    to_tsvector('chat_search', COALESCE(content, '') || ' ' || COALESCE(file_name, ''))
+);
@@ -0,0 +1 @@
+ALTER TABLE messages ADD COLUMN system_payload JSONB;
@@ -0,0 +1,6 @@
+ALTER TABLE participants
+    ADD COLUMN IF NOT EXISTS muted_until TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS is_archived BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN IF NOT EXISTS pinned_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS idx_participants_user_archived ON participants(user_id, is_archived);
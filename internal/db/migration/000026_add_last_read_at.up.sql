@@ -0,0 +1 @@
+ALTER TABLE participants ADD COLUMN IF NOT EXISTS last_read_at TIMESTAMPTZ;
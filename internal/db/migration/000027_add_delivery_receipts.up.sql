@@ -0,0 +1,3 @@
+ALTER TABLE participants
+    ADD COLUMN IF NOT EXISTS last_delivered_message_id BIGINT,
+    ADD COLUMN IF NOT EXISTS last_delivered_at TIMESTAMPTZ;
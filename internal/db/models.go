@@ -5,6 +5,8 @@
 package db
 
 import (
+	"encoding/json"
+
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
@@ -19,6 +21,19 @@ type Conversation struct {
 	UpdatedAt     pgtype.Timestamp `json:"updated_at"`
 }
 
+type FileCleanup struct {
+	ID        int64              `json:"id"`
+	FilePath  string             `json:"file_path"`
+	RunAfter  pgtype.Timestamptz `json:"run_after"`
+	CreatedAt pgtype.Timestamptz `json:"created_at"`
+}
+
+type HiddenMessage struct {
+	UserID    string             `json:"user_id"`
+	MessageID int64              `json:"message_id"`
+	HiddenAt  pgtype.Timestamptz `json:"hidden_at"`
+}
+
 type Meeting struct {
 	ID          pgtype.UUID        `json:"id"`
 	Title       string             `json:"title"`
@@ -38,26 +53,68 @@ type MeetingInvite struct {
 }
 
 type Message struct {
-	ID             int64            `json:"id"`
-	ConversationID int64            `json:"conversation_id"`
-	SenderID       string           `json:"sender_id"`
-	Content        pgtype.Text      `json:"content"`
-	Type           pgtype.Text      `json:"type"`
-	ReplyToID      pgtype.Int8      `json:"reply_to_id"`
-	IsDeleted      pgtype.Bool      `json:"is_deleted"`
-	CreatedAt      pgtype.Timestamp `json:"created_at"`
-	FileName       pgtype.Text      `json:"file_name"`
-	FileID         pgtype.Text      `json:"file_id"`
-	FilePath       pgtype.Text      `json:"file_path"`
-	FileType       pgtype.Text      `json:"file_type"`
-	FileSize       pgtype.Int8      `json:"file_size"`
-	ClientMsgID    pgtype.Text      `json:"client_msg_id"`
+	ID             int64              `json:"id"`
+	ConversationID int64              `json:"conversation_id"`
+	SenderID       string             `json:"sender_id"`
+	Content        pgtype.Text        `json:"content"`
+	Type           pgtype.Text        `json:"type"`
+	ReplyToID      pgtype.Int8        `json:"reply_to_id"`
+	IsDeleted      pgtype.Bool        `json:"is_deleted"`
+	CreatedAt      pgtype.Timestamp   `json:"created_at"`
+	FileName       pgtype.Text        `json:"file_name"`
+	FileID         pgtype.Text        `json:"file_id"`
+	FilePath       pgtype.Text        `json:"file_path"`
+	FileType       pgtype.Text        `json:"file_type"`
+	FileSize       pgtype.Int8        `json:"file_size"`
+	ClientMsgID    pgtype.Text        `json:"client_msg_id"`
+	EditedAt       pgtype.Timestamptz `json:"edited_at"`
+	Mentions       json.RawMessage    `json:"mentions"`
+	ForwardedFrom  pgtype.Int8        `json:"forwarded_from"`
+	SystemPayload  json.RawMessage    `json:"system_payload"`
+}
+
+type MessageEdit struct {
+	ID        int64              `json:"id"`
+	MessageID int64              `json:"message_id"`
+	Content   pgtype.Text        `json:"content"`
+	EditedAt  pgtype.Timestamptz `json:"edited_at"`
+}
+
+type MessageReaction struct {
+	MessageID int64              `json:"message_id"`
+	UserID    string             `json:"user_id"`
+	Emoji     string             `json:"emoji"`
+	CreatedAt pgtype.Timestamptz `json:"created_at"`
 }
 
 type Participant struct {
-	ConversationID    int64            `json:"conversation_id"`
-	UserID            string           `json:"user_id"`
-	Role              pgtype.Text      `json:"role"`
-	JoinedAt          pgtype.Timestamp `json:"joined_at"`
-	LastReadMessageID pgtype.Int8      `json:"last_read_message_id"`
+	ConversationID         int64              `json:"conversation_id"`
+	UserID                 string             `json:"user_id"`
+	Role                   pgtype.Text        `json:"role"`
+	JoinedAt               pgtype.Timestamp   `json:"joined_at"`
+	LastReadMessageID      pgtype.Int8        `json:"last_read_message_id"`
+	MutedUntil             pgtype.Timestamptz `json:"muted_until"`
+	IsArchived             bool               `json:"is_archived"`
+	PinnedAt               pgtype.Timestamptz `json:"pinned_at"`
+	LastReadAt             pgtype.Timestamptz `json:"last_read_at"`
+	LastDeliveredMessageID pgtype.Int8        `json:"last_delivered_message_id"`
+	LastDeliveredAt        pgtype.Timestamptz `json:"last_delivered_at"`
+}
+
+type PinnedMessage struct {
+	ConversationID int64              `json:"conversation_id"`
+	MessageID      int64              `json:"message_id"`
+	PinnedBy       string             `json:"pinned_by"`
+	PinnedAt       pgtype.Timestamptz `json:"pinned_at"`
+}
+
+type Upload struct {
+	FilePath  string             `json:"file_path"`
+	UserID    string             `json:"user_id"`
+	CreatedAt pgtype.Timestamptz `json:"created_at"`
+}
+
+type UserPresence struct {
+	UserID     string             `json:"user_id"`
+	LastSeenAt pgtype.Timestamptz `json:"last_seen_at"`
 }
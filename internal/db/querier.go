@@ -13,29 +13,77 @@ import (
 type Querier interface {
 	AddMeetingInvite(ctx context.Context, arg AddMeetingInviteParams) error
 	AddParticipant(ctx context.Context, arg AddParticipantParams) error
+	AddReaction(ctx context.Context, arg AddReactionParams) (int64, error)
 	CheckJoinPermission(ctx context.Context, arg CheckJoinPermissionParams) (bool, error)
+	CountMessagesByFilePath(ctx context.Context, filePath pgtype.Text) (int64, error)
+	CountPinnedConversations(ctx context.Context, arg CountPinnedConversationsParams) (int64, error)
+	CountPinnedMessages(ctx context.Context, conversationID int64) (int64, error)
+	CountRepliesByParentIDs(ctx context.Context, parentIds []int64) ([]CountRepliesByParentIDsRow, error)
 	CreateConversation(ctx context.Context, arg CreateConversationParams) (Conversation, error)
 	CreateMeeting(ctx context.Context, arg CreateMeetingParams) (Meeting, error)
 	CreateMessage(ctx context.Context, arg CreateMessageParams) (Message, error)
+	DeleteFileCleanup(ctx context.Context, id int64) error
+	DeleteMessageEdits(ctx context.Context, messageID int64) error
+	EditMessage(ctx context.Context, arg EditMessageParams) (Message, error)
 	EndMeeting(ctx context.Context, arg EndMeetingParams) (Meeting, error)
 	GetActiveMeetingByKey(ctx context.Context, meetingKey string) (Meeting, error)
 	GetConversationByID(ctx context.Context, id int64) (GetConversationByIDRow, error)
 	GetMeetingByID(ctx context.Context, id pgtype.UUID) (Meeting, error)
 	GetMeetingByRoomName(ctx context.Context, roomName string) (Meeting, error)
 	GetMeetingInvites(ctx context.Context, meetingID pgtype.UUID) ([]string, error)
+	GetMessageByClientMsgID(ctx context.Context, arg GetMessageByClientMsgIDParams) (Message, error)
+	GetMessageByID(ctx context.Context, arg GetMessageByIDParams) (Message, error)
 	GetMessagesByConversation(ctx context.Context, arg GetMessagesByConversationParams) ([]Message, error)
 	GetPrivateConversation(ctx context.Context, arg GetPrivateConversationParams) (int64, error)
+	GetReadPosition(ctx context.Context, arg GetReadPositionParams) (GetReadPositionRow, error)
 	GetTotalUnreadCount(ctx context.Context, userID string) (int64, error)
+	GetUploadOwner(ctx context.Context, filePath string) (string, error)
+	HideMessage(ctx context.Context, arg HideMessageParams) error
+	IsMessagePinned(ctx context.Context, arg IsMessagePinnedParams) (bool, error)
+	ListConversationMessagesSince(ctx context.Context, arg ListConversationMessagesSinceParams) ([]Message, error)
+	ListConversationPartnerIDs(ctx context.Context, userID string) ([]string, error)
 	ListConversationsByUser(ctx context.Context, arg ListConversationsByUserParams) ([]ListConversationsByUserRow, error)
+	ListDueFileCleanups(ctx context.Context, limit int32) ([]FileCleanup, error)
 	ListMeetingsForUser(ctx context.Context, userID string) ([]Meeting, error)
+	ListMessageEdits(ctx context.Context, arg ListMessageEditsParams) ([]MessageEdit, error)
+	ListMessageReaders(ctx context.Context, arg ListMessageReadersParams) ([]ListMessageReadersRow, error)
+	ListMessageSendersBetween(ctx context.Context, arg ListMessageSendersBetweenParams) ([]string, error)
+	ListMessagesByIDs(ctx context.Context, ids []int64) ([]Message, error)
+	ListMessagesSince(ctx context.Context, arg ListMessagesSinceParams) ([]Message, error)
+	ListMutedParticipants(ctx context.Context, conversationID int64) ([]string, error)
 	ListMyMeetings(ctx context.Context, userID string) ([]Meeting, error)
 	ListParticipantsByConversation(ctx context.Context, conversationID int64) ([]ListParticipantsByConversationRow, error)
-	MarkMessageAsRead(ctx context.Context, arg MarkMessageAsReadParams) error
+	ListPinnedMessages(ctx context.Context, conversationID int64) ([]ListPinnedMessagesRow, error)
+	ListReactionSummaries(ctx context.Context, arg ListReactionSummariesParams) ([]ListReactionSummariesRow, error)
+	ListReadStatesSince(ctx context.Context, arg ListReadStatesSinceParams) ([]ListReadStatesSinceRow, error)
+	ListThreadReplies(ctx context.Context, arg ListThreadRepliesParams) ([]Message, error)
+	ListUsersLastSeen(ctx context.Context, userIds []string) ([]UserPresence, error)
+	// Serializes changes that must check and update a conversation atomically,
+	// such as its pins or its admins, without blocking new messages.
+	LockConversation(ctx context.Context, id int64) error
+	// Serializes changes to one user's conversation list, such as pinning,
+	// that must count and update their participant rows atomically.
+	LockUserParticipants(ctx context.Context, userID string) error
+	MarkMessageAsDelivered(ctx context.Context, arg MarkMessageAsDeliveredParams) (pgtype.Timestamptz, error)
+	MarkMessageAsRead(ctx context.Context, arg MarkMessageAsReadParams) (pgtype.Timestamptz, error)
+	PinMessage(ctx context.Context, arg PinMessageParams) (int64, error)
+	RecallMessage(ctx context.Context, arg RecallMessageParams) (Message, error)
+	RecordUpload(ctx context.Context, arg RecordUploadParams) error
 	RemoveParticipant(ctx context.Context, arg RemoveParticipantParams) error
+	RemoveReaction(ctx context.Context, arg RemoveReactionParams) (int64, error)
+	ScheduleFileCleanup(ctx context.Context, arg ScheduleFileCleanupParams) error
+	SearchMessages(ctx context.Context, arg SearchMessagesParams) ([]SearchMessagesRow, error)
+	SetConversationArchived(ctx context.Context, arg SetConversationArchivedParams) (Participant, error)
+	SetConversationMute(ctx context.Context, arg SetConversationMuteParams) (Participant, error)
+	SetConversationPinned(ctx context.Context, arg SetConversationPinnedParams) (Participant, error)
+	UnarchiveConversation(ctx context.Context, conversationID int64) error
+	UnpinMessage(ctx context.Context, arg UnpinMessageParams) (int64, error)
 	UpdateConversationInfo(ctx context.Context, arg UpdateConversationInfoParams) error
 	UpdateConversationLastMessage(ctx context.Context, arg UpdateConversationLastMessageParams) error
 	UpdateLastReadMessage(ctx context.Context, arg UpdateLastReadMessageParams) error
 	UpdateMeetingStatus(ctx context.Context, arg UpdateMeetingStatusParams) error
+	UpdateParticipantRole(ctx context.Context, arg UpdateParticipantRoleParams) error
+	UpsertUserLastSeen(ctx context.Context, arg UpsertUserLastSeenParams) error
 }
 
 var _ Querier = (*Queries)(nil)
@@ -14,7 +14,8 @@ SELECT
     m.content as last_message_content,
     m.sender_id as last_message_sender_id,
     m.type as last_message_type,
-    m.file_name as last_message_file_name
+    m.file_name as last_message_file_name,
+    m.is_deleted as last_message_is_deleted
 FROM conversations c
 LEFT JOIN messages m ON c.last_message_id = m.id
 WHERE c.id = $1 LIMIT 1;
@@ -25,7 +26,8 @@ SET
     last_message_id = $2, 
     last_message_at = $3,
     updated_at = now()
-WHERE id = $1;
+WHERE id = $1
+  AND (last_message_id IS NULL OR last_message_id < $2);
 
 -- name: UpdateConversationInfo :exec
 UPDATE conversations
@@ -35,6 +37,13 @@ SET
     updated_at = now()
 WHERE id = $1;
 
+-- name: LockConversation :exec
+-- Serializes changes that must check and update a conversation atomically,
+-- such as its pins or its admins, without blocking new messages.
+SELECT id FROM conversations
+WHERE id = $1
+FOR NO KEY UPDATE;
+
 -- name: AddParticipant :exec
 INSERT INTO participants (
     conversation_id, 
@@ -42,17 +51,30 @@ INSERT INTO participants (
     role
 ) VALUES (
     $1, $2, $3
-);
+)
+ON CONFLICT (conversation_id, user_id) DO NOTHING;
 
 -- name: RemoveParticipant :exec
 DELETE FROM participants 
 WHERE conversation_id = $1 AND user_id = $2;
 
+-- name: UpdateParticipantRole :exec
+UPDATE participants
+SET role = $3
+WHERE conversation_id = $1 AND user_id = $2;
+
 -- name: ListParticipantsByConversation :many
-SELECT user_id, role, joined_at, last_read_message_id
+SELECT user_id, role, joined_at, last_read_message_id, last_delivered_message_id
 FROM participants 
 WHERE conversation_id = $1;
 
+-- name: ListConversationPartnerIDs :many
+SELECT DISTINCT p2.user_id
+FROM participants p1
+JOIN participants p2 ON p1.conversation_id = p2.conversation_id
+WHERE p1.user_id = $1
+  AND p2.user_id != $1;
+
 
 -- name: CreateMessage :one
 INSERT INTO messages (
@@ -65,23 +87,176 @@ INSERT INTO messages (
     file_type, 
     file_size,
     reply_to_id,
-    client_msg_id
+    client_msg_id,
+    mentions,
+    forwarded_from,
+    system_payload
 ) VALUES (
-    $1, $2, $3, $4, $5, $6, $7, $8, $9, $10
+    $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13
 )
+ON CONFLICT (conversation_id, client_msg_id) DO NOTHING
 RETURNING *;
 
+-- name: GetMessageByID :one
+SELECT * FROM messages
+WHERE id = $1 AND conversation_id = $2
+LIMIT 1;
+
+-- name: GetMessageByClientMsgID :one
+SELECT * FROM messages
+WHERE conversation_id = $1 AND client_msg_id = $2
+LIMIT 1;
+
 -- name: GetMessagesByConversation :many
 SELECT * FROM messages
 WHERE conversation_id = sqlc.arg('conversation_id')
 AND (sqlc.arg('before_id')::bigint = 0 OR id < sqlc.arg('before_id'))
+AND NOT EXISTS (
+    SELECT 1 FROM hidden_messages h
+    WHERE h.message_id = messages.id AND h.user_id = sqlc.arg('user_id')
+)
 ORDER BY id DESC
 LIMIT sqlc.arg('limit_count');
 
--- name: MarkMessageAsRead :exec
+-- name: ListMessagesByIDs :many
+SELECT * FROM messages
+WHERE id = ANY(sqlc.arg('ids')::bigint[]);
+
+-- name: ListThreadReplies :many
+SELECT * FROM messages
+WHERE conversation_id = sqlc.arg('conversation_id')
+  AND reply_to_id = sqlc.arg('parent_id')
+  AND id > sqlc.arg('after_id')::bigint
+  AND NOT EXISTS (
+      SELECT 1 FROM hidden_messages h
+      WHERE h.message_id = messages.id AND h.user_id = sqlc.arg('user_id')
+  )
+ORDER BY id ASC
+LIMIT sqlc.arg('limit_count');
+
+-- name: CountRepliesByParentIDs :many
+SELECT reply_to_id::bigint AS parent_id, COUNT(*) AS reply_count
+FROM messages
+WHERE reply_to_id = ANY(sqlc.arg('parent_ids')::bigint[])
+  AND is_deleted IS NOT TRUE
+GROUP BY reply_to_id;
+
+-- name: ListMessagesSince :many
+SELECT m.* FROM messages m
+JOIN participants p ON p.conversation_id = m.conversation_id
+WHERE p.user_id = sqlc.arg('user_id')
+  AND m.id > sqlc.arg('after_id')::bigint
+  AND NOT EXISTS (
+      SELECT 1 FROM hidden_messages h
+      WHERE h.message_id = m.id AND h.user_id = sqlc.arg('user_id')
+  )
+ORDER BY m.id ASC
+LIMIT sqlc.arg('limit_count');
+
+-- name: ListConversationMessagesSince :many
+SELECT * FROM messages
+WHERE conversation_id = sqlc.arg('conversation_id')
+  AND id > sqlc.arg('after_id')::bigint
+  AND NOT EXISTS (
+      SELECT 1 FROM hidden_messages h
+      WHERE h.message_id = messages.id AND h.user_id = sqlc.arg('user_id')
+  )
+ORDER BY id ASC
+LIMIT sqlc.arg('limit_count');
+
+-- name: ListReadStatesSince :many
+SELECT p2.conversation_id, p2.user_id, p2.last_read_message_id, p2.last_read_at
+FROM participants p1
+JOIN participants p2 ON p2.conversation_id = p1.conversation_id
+WHERE p1.user_id = sqlc.arg('user_id')
+  AND p2.last_read_message_id > sqlc.arg('after_id')::bigint;
+
+-- name: EditMessage :one
+WITH prev AS (
+    SELECT id, content FROM messages
+    WHERE id = sqlc.arg('id')
+      AND conversation_id = sqlc.arg('conversation_id')
+      AND sender_id = sqlc.arg('sender_id')
+      AND is_deleted IS NOT TRUE
+      AND type IS DISTINCT FROM 'system'
+    FOR UPDATE
+), history AS (
+    INSERT INTO message_edits (message_id, content)
+    SELECT id, content FROM prev
+)
+UPDATE messages m
+SET content = sqlc.arg('content'),
+    edited_at = now()
+FROM prev
+WHERE m.id = prev.id
+RETURNING m.*;
+
+-- name: RecallMessage :one
+UPDATE messages
+SET is_deleted = TRUE,
+    content = NULL,
+    file_name = NULL,
+    file_id = NULL,
+    file_path = NULL,
+    file_type = NULL,
+    file_size = NULL,
+    mentions = NULL
+WHERE id = $1
+  AND conversation_id = $2
+  AND is_deleted IS NOT TRUE
+RETURNING *;
+
+-- name: DeleteMessageEdits :exec
+DELETE FROM message_edits WHERE message_id = $1;
+
+-- name: HideMessage :exec
+INSERT INTO hidden_messages (user_id, message_id)
+VALUES ($1, $2)
+ON CONFLICT DO NOTHING;
+
+-- name: RecordUpload :exec
+INSERT INTO uploads (file_path, user_id)
+VALUES ($1, $2);
+
+-- name: GetUploadOwner :one
+SELECT user_id FROM uploads
+WHERE file_path = $1;
+
+-- name: ScheduleFileCleanup :exec
+INSERT INTO file_cleanups (file_path, run_after)
+VALUES ($1, $2);
+
+-- name: ListDueFileCleanups :many
+SELECT * FROM file_cleanups
+WHERE run_after <= now()
+ORDER BY run_after
+LIMIT $1;
+
+-- name: DeleteFileCleanup :exec
+DELETE FROM file_cleanups WHERE id = $1;
+
+-- name: CountMessagesByFilePath :one
+SELECT COUNT(*) FROM messages
+WHERE file_path = $1 AND is_deleted IS NOT TRUE;
+
+-- name: ListMessageEdits :many
+SELECT e.* FROM message_edits e
+JOIN messages m ON m.id = e.message_id
+WHERE e.message_id = $1
+  AND m.conversation_id = $2
+  AND m.is_deleted IS NOT TRUE
+ORDER BY e.edited_at DESC;
+
+-- name: MarkMessageAsRead :one
+-- Read positions only move forward; no row is returned otherwise. A read
+-- message has obviously been delivered too.
 UPDATE participants
-SET last_read_message_id = $3
-WHERE conversation_id = $1 AND user_id = $2;
+SET last_read_message_id = $3,
+    last_read_at = now(),
+    last_delivered_message_id = GREATEST(COALESCE(last_delivered_message_id, 0), $3)
+WHERE conversation_id = $1 AND user_id = $2
+  AND COALESCE(last_read_message_id, 0) < $3
+RETURNING last_read_at;
 
 -- name: ListConversationsByUser :many
 SELECT 
@@ -95,14 +270,32 @@ SELECT
     m.sender_id as last_message_sender_id,
     m.type as last_message_type,
     m.file_name as last_message_file_name,
+    m.is_deleted as last_message_is_deleted,
     p.last_read_message_id,
+    p.muted_until,
+    p.is_archived,
+    p.pinned_at,
     (
         SELECT COUNT(m2.id) 
         FROM messages m2 
         WHERE m2.conversation_id = c.id 
           AND m2.id > COALESCE(p.last_read_message_id, 0)
           AND m2.sender_id != $1
+          AND m2.is_deleted IS NOT TRUE
+          AND m2.type IS DISTINCT FROM 'system'
     ) as unread_count,
+    (
+        SELECT COUNT(m3.id)
+        FROM messages m3
+        WHERE m3.conversation_id = c.id
+          AND m3.id > COALESCE(p.last_read_message_id, 0)
+          AND m3.sender_id != $1
+          AND m3.is_deleted IS NOT TRUE
+          AND (
+              m3.mentions @> jsonb_build_array(jsonb_build_object('user_id', $1::text))
+              OR m3.mentions @> '[{"user_id": "all"}]'
+          )
+    ) as unread_mention_count,
     (
         SELECT ARRAY_AGG(user_id)::TEXT[] 
         FROM participants 
@@ -110,9 +303,23 @@ SELECT
     ) as participant_ids
 FROM conversations c
 JOIN participants p ON c.id = p.conversation_id
-LEFT JOIN messages m ON c.last_message_id = m.id
+-- The preview is the newest message the user has not deleted for themselves.
+LEFT JOIN LATERAL (
+    SELECT lm.content, lm.sender_id, lm.type, lm.file_name, lm.is_deleted
+    FROM messages lm
+    WHERE lm.conversation_id = c.id
+      AND lm.id <= c.last_message_id
+      AND NOT EXISTS (
+          SELECT 1 FROM hidden_messages h
+          WHERE h.message_id = lm.id AND h.user_id = $1
+      )
+    ORDER BY lm.id DESC
+    LIMIT 1
+) m ON TRUE
 WHERE p.user_id = $1
-ORDER BY c.last_message_at DESC
+  AND p.is_archived = $4
+-- Pinned conversations come first, most recently pinned on top.
+ORDER BY p.pinned_at DESC NULLS LAST, c.last_message_at DESC
 LIMIT $2 OFFSET $3;
 
 -- name: GetPrivateConversation :one
@@ -138,5 +345,14 @@ FROM messages m
 INNER JOIN participants p ON m.conversation_id = p.conversation_id
 WHERE p.user_id = $1 
   AND m.id > COALESCE(p.last_read_message_id, 0)
-  AND m.sender_id != $1;
+  AND m.sender_id != $1
+  AND m.is_deleted IS NOT TRUE
+  AND m.type IS DISTINCT FROM 'system'
+  -- Muted conversations only count messages that mention the user.
+  AND (
+      p.muted_until IS NULL
+      OR p.muted_until <= now()
+      OR m.mentions @> jsonb_build_array(jsonb_build_object('user_id', $1::text))
+      OR m.mentions @> '[{"user_id": "all"}]'
+  );
 
@@ -0,0 +1,28 @@
+-- name: PinMessage :execrows
+INSERT INTO pinned_messages (conversation_id, message_id, pinned_by)
+VALUES ($1, $2, $3)
+ON CONFLICT DO NOTHING;
+
+-- name: IsMessagePinned :one
+SELECT EXISTS (
+    SELECT 1 FROM pinned_messages
+    WHERE conversation_id = $1 AND message_id = $2
+);
+
+-- name: UnpinMessage :execrows
+DELETE FROM pinned_messages
+WHERE conversation_id = $1 AND message_id = $2;
+
+-- name: CountPinnedMessages :one
+SELECT COUNT(*) FROM pinned_messages pm
+JOIN messages m ON m.id = pm.message_id
+WHERE pm.conversation_id = $1
+  AND m.is_deleted IS NOT TRUE;
+
+-- name: ListPinnedMessages :many
+SELECT sqlc.embed(m), pm.pinned_by, pm.pinned_at
+FROM pinned_messages pm
+JOIN messages m ON m.id = pm.message_id
+WHERE pm.conversation_id = $1
+  AND m.is_deleted IS NOT TRUE
+ORDER BY pm.pinned_at DESC;
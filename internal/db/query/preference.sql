@@ -0,0 +1,49 @@
+-- name: SetConversationMute :one
+UPDATE participants
+SET muted_until = sqlc.narg('muted_until')
+WHERE conversation_id = sqlc.arg('conversation_id') AND user_id = sqlc.arg('user_id')
+RETURNING *;
+
+-- name: SetConversationArchived :one
+-- Archiving a conversation also takes it off the pinned list.
+UPDATE participants
+SET is_archived = sqlc.arg('is_archived'),
+    pinned_at = CASE WHEN sqlc.arg('is_archived')::boolean THEN NULL ELSE pinned_at END
+WHERE conversation_id = sqlc.arg('conversation_id') AND user_id = sqlc.arg('user_id')
+RETURNING *;
+
+-- name: SetConversationPinned :one
+-- Pinning a conversation brings it back to the inbox.
+UPDATE participants
+SET pinned_at = sqlc.narg('pinned_at'),
+    is_archived = is_archived AND sqlc.narg('pinned_at')::timestamptz IS NULL
+WHERE conversation_id = sqlc.arg('conversation_id') AND user_id = sqlc.arg('user_id')
+RETURNING *;
+
+-- name: CountPinnedConversations :one
+-- Counts the user's pinned conversations other than the given one.
+SELECT COUNT(*) FROM participants
+WHERE user_id = $1
+  AND conversation_id != $2
+  AND pinned_at IS NOT NULL;
+
+-- name: LockUserParticipants :exec
+-- Serializes changes to one user's conversation list, such as pinning,
+-- that must count and update their participant rows atomically.
+SELECT conversation_id FROM participants
+WHERE user_id = $1
+FOR NO KEY UPDATE;
+
+-- name: ListMutedParticipants :many
+SELECT user_id FROM participants
+WHERE conversation_id = $1
+  AND muted_until > now();
+
+-- name: UnarchiveConversation :exec
+-- A new message brings the conversation back to the inbox of every member
+-- who archived it without muting it.
+UPDATE participants
+SET is_archived = FALSE
+WHERE conversation_id = $1
+  AND is_archived
+  AND (muted_until IS NULL OR muted_until <= now());
@@ -0,0 +1,13 @@
+-- name: UpsertUserLastSeen :exec
+INSERT INTO user_presence (
+    user_id,
+    last_seen_at
+) VALUES (
+    $1, $2
+)
+ON CONFLICT (user_id) DO UPDATE
+SET last_seen_at = EXCLUDED.last_seen_at;
+
+-- name: ListUsersLastSeen :many
+SELECT * FROM user_presence
+WHERE user_id = ANY(sqlc.arg('user_ids')::text[]);
@@ -0,0 +1,31 @@
+-- name: GetReadPosition :one
+SELECT p.last_read_message_id, p.last_delivered_message_id, c.last_message_id
+FROM participants p
+JOIN conversations c ON c.id = p.conversation_id
+WHERE p.conversation_id = $1 AND p.user_id = $2;
+
+-- name: ListMessageReaders :many
+-- Members whose read position covers the message, sender excluded.
+SELECT user_id, last_read_message_id, last_read_at
+FROM participants
+WHERE conversation_id = sqlc.arg('conversation_id')
+  AND last_read_message_id >= sqlc.arg('message_id')::bigint
+  AND user_id != sqlc.arg('sender_id')
+ORDER BY last_read_at DESC NULLS LAST;
+
+-- name: MarkMessageAsDelivered :one
+-- The delivered watermark only moves forward; no row is returned otherwise.
+UPDATE participants
+SET last_delivered_message_id = $3,
+    last_delivered_at = now()
+WHERE conversation_id = $1 AND user_id = $2
+  AND COALESCE(last_delivered_message_id, 0) < $3
+RETURNING last_delivered_at;
+
+-- name: ListMessageSendersBetween :many
+SELECT DISTINCT sender_id FROM messages
+WHERE conversation_id = sqlc.arg('conversation_id')
+  AND id > sqlc.arg('after_id')::bigint
+  AND id <= sqlc.arg('up_to_id')::bigint
+  AND sender_id != sqlc.arg('user_id')
+  AND type IS DISTINCT FROM 'system';
@@ -0,0 +1,31 @@
+-- name: SearchMessages :many
+SELECT
+    m.id,
+    m.conversation_id,
+    m.sender_id,
+    m.type,
+    m.file_name,
+    m.created_at,
+    ts_headline(
+        'chat_search',
+        COALESCE(m.content, m.file_name, ''),
+        q.query,
+        'StartSel=<mark>, StopSel=</mark>, MaxFragments=2, MaxWords=20, MinWords=5'
+    )::text AS snippet
+FROM messages m
+JOIN participants p ON p.conversation_id = m.conversation_id AND p.user_id = sqlc.arg('user_id')
+CROSS JOIN websearch_to_tsquery('chat_search', sqlc.arg('query')::text) AS q(query)
+WHERE to_tsvector('chat_search', COALESCE(m.content, '') || ' ' || COALESCE(m.file_name, '')) @@ q.query
+  AND m.is_deleted IS NOT TRUE
+  AND NOT EXISTS (
+      SELECT 1 FROM hidden_messages h
+      WHERE h.message_id = m.id AND h.user_id = sqlc.arg('user_id')
+  )
+  AND (sqlc.arg('conversation_id')::bigint = 0 OR m.conversation_id = sqlc.arg('conversation_id'))
+  AND (sqlc.arg('sender_id')::text = '' OR m.sender_id = sqlc.arg('sender_id'))
+  AND (sqlc.arg('msg_type')::text = '' OR m.type = sqlc.arg('msg_type'))
+  AND (sqlc.narg('from_time')::timestamptz IS NULL OR m.created_at >= sqlc.narg('from_time'))
+  AND (sqlc.narg('to_time')::timestamptz IS NULL OR m.created_at < sqlc.narg('to_time'))
+  AND (sqlc.arg('before_id')::bigint = 0 OR m.id < sqlc.arg('before_id'))
+ORDER BY m.id DESC
+LIMIT sqlc.arg('limit_count');
@@ -3,6 +3,7 @@ package db
 import (
 	"context"
 	"corechain-communication/internal/config"
+	"strconv"
 	"sync"
 	"time"
 
@@ -15,8 +16,8 @@ var (
 )
 
 const (
-	UserStatusTTL  = 0              // Online status never expired
-	UserProfileTTL = 24 * time.Hour // Cache user info in one day
+	UserStatusTTL  = 90 * time.Second // Refreshed by every WebSocket pong
+	UserProfileTTL = 24 * time.Hour   // Cache user info in one day
 )
 
 func InitRedis() {
@@ -32,13 +33,23 @@ func GetRedis() *redis.Client {
 	return redisClient
 }
 
+// SetUserOnline marks the user online and records them as seen now. Call it
+// again on every heartbeat to keep the status from expiring.
 func SetUserOnline(ctx context.Context, userID string) error {
-	return redisClient.Set(ctx, "online:"+userID, "true", UserStatusTTL).Err()
+	pipe := redisClient.TxPipeline()
+	pipe.Set(ctx, "online:"+userID, "true", UserStatusTTL)
+	pipe.Set(ctx, "last_seen:"+userID, time.Now().UTC().Unix(), 0)
+	_, err := pipe.Exec(ctx)
+	return err
 }
 
 // SetUserOffline when user disconnected
 func SetUserOffline(ctx context.Context, userID string) error {
-	return redisClient.Del(ctx, "online:"+userID).Err()
+	pipe := redisClient.TxPipeline()
+	pipe.Del(ctx, "online:"+userID)
+	pipe.Set(ctx, "last_seen:"+userID, time.Now().UTC().Unix(), 0)
+	_, err := pipe.Exec(ctx)
+	return err
 }
 
 // IsUserOnline check is user online
@@ -47,6 +58,35 @@ func IsUserOnline(ctx context.Context, userID string) bool {
 	return err == nil && val == "true"
 }
 
+// GetUsersPresence returns, for each user, whether they are online and the
+// last time they were seen. Users never seen are absent from lastSeen.
+func GetUsersPresence(ctx context.Context, userIDs []string) (online map[string]bool, lastSeen map[string]time.Time, err error) {
+	online = make(map[string]bool, len(userIDs))
+	lastSeen = make(map[string]time.Time, len(userIDs))
+	if len(userIDs) == 0 {
+		return online, lastSeen, nil
+	}
+
+	keys := make([]string, 0, 2*len(userIDs))
+	for _, id := range userIDs {
+		keys = append(keys, "online:"+id, "last_seen:"+id)
+	}
+	vals, err := redisClient.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for i, id := range userIDs {
+		online[id] = vals[2*i] == "true"
+		if s, ok := vals[2*i+1].(string); ok {
+			if sec, err := strconv.ParseInt(s, 10, 64); err == nil {
+				lastSeen[id] = time.Unix(sec, 0).UTC()
+			}
+		}
+	}
+	return online, lastSeen, nil
+}
+
 func CacheUserProfile(ctx context.Context, userID string, profileData []byte) error {
 	return redisClient.Set(ctx, "profile:"+userID, profileData, UserProfileTTL).Err()
 }
@@ -62,6 +102,11 @@ func CacheParticipants(ctx context.Context, convID string, userIDs []string) err
 	return redisClient.SAdd(ctx, key, userIDs).Err()
 }
 
+// DeleteCachedParticipants drops the cached members of a conversation
+func DeleteCachedParticipants(ctx context.Context, convID string) error {
+	return redisClient.Del(ctx, "conv_members:"+convID).Err()
+}
+
 // GetCachedParticipants
 func GetCachedParticipants(ctx context.Context, convID string) ([]string, error) {
 	key := "conv_members:" + convID
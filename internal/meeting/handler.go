@@ -1,6 +1,7 @@
 package meeting
 
 import (
+	"corechain-communication/internal/chat"
 	"corechain-communication/internal/db"
 	"net/http"
 	"time"
@@ -10,11 +11,13 @@ import (
 
 type MeetingHandler struct {
 	service *MeetingService
+	hub     *chat.Hub
 }
 
-func NewMeetingHandler(service *MeetingService) *MeetingHandler {
+func NewMeetingHandler(service *MeetingService, hub *chat.Hub) *MeetingHandler {
 	return &MeetingHandler{
 		service: service,
+		hub:     hub,
 	}
 }
 
@@ -37,6 +40,8 @@ func (h *MeetingHandler) CreateMeeting(w http.ResponseWriter, r *http.Request) {
 		Description    string     `json:"description"`
 		InvitedUserIDs []string   `json:"invited_user_ids"`
 		StartTime      *time.Time `json:"start_time"`
+		// ConversationID optionally announces the meeting in a chat.
+		ConversationID int64 `json:"conversation_id"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -44,6 +49,18 @@ func (h *MeetingHandler) CreateMeeting(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if req.ConversationID != 0 {
+		ok, err := h.hub.IsMember(r.Context(), req.ConversationID, userID)
+		if err != nil {
+			h.renderJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		if !ok {
+			h.renderJSON(w, http.StatusForbidden, map[string]string{"error": "Not a member of this conversation"})
+			return
+		}
+	}
+
 	scheduledTime := time.Now().UTC()
 	if req.StartTime != nil {
 		scheduledTime = *req.StartTime
@@ -55,6 +72,22 @@ func (h *MeetingHandler) CreateMeeting(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if req.ConversationID != 0 {
+		userName, _ := r.Context().Value("user_name").(string)
+		payload := chat.SystemPayload{
+			Action:       chat.SystemMeetingStarted,
+			Actor:        chat.SystemUser{ID: userID, Name: userName},
+			MeetingID:    meeting.ID.String(),
+			MeetingTitle: meeting.Title,
+			RoomName:     meeting.RoomName,
+		}
+		if scheduledTime.After(time.Now()) {
+			payload.Action = chat.SystemMeetingScheduled
+			payload.StartsAt = &scheduledTime
+		}
+		h.hub.PostSystemMessage(r.Context(), req.ConversationID, payload)
+	}
+
 	h.renderJSON(w, http.StatusCreated, meeting)
 }
 
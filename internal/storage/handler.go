@@ -9,12 +9,23 @@ import (
 	"path/filepath"
 	"time"
 
+	"corechain-communication/internal/db"
+
 	"github.com/google/uuid"
 	"github.com/minio/minio-go/v7"
 )
 
-func UploadHandler(w http.ResponseWriter, r *http.Request) {
+// NewUploadHandler stores files in MinIO and records the uploader, so the
+// returned file_path can only be attached to messages by the same user.
+func NewUploadHandler(q *db.Queries) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		upload(q, w, r)
+	}
+}
+
+func upload(q *db.Queries, w http.ResponseWriter, r *http.Request) {
 	log.Println("UploadHandler called")
+	userID := r.Context().Value("user_id").(string)
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
@@ -46,6 +57,16 @@ func UploadHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	err = q.RecordUpload(ctx, db.RecordUploadParams{FilePath: info.Key, UserID: userID})
+	if err != nil {
+		log.Printf("Error recording upload %s: %v", info.Key, err)
+		if err := RemoveObject(context.Background(), info.Key); err != nil {
+			log.Printf("Error removing unrecorded upload %s: %v", info.Key, err)
+		}
+		http.Error(w, "Upload failed", http.StatusInternalServerError)
+		return
+	}
+
 	fileData := map[string]interface{}{
 		"file_id":   info.Key,
 		"file_name": header.Filename,
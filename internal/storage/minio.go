@@ -63,3 +63,7 @@ func GetPresignedURL(objectName string) (string, error) {
 
 	return presignedURL.String(), nil
 }
+
+func RemoveObject(ctx context.Context, objectName string) error {
+	return Instance.Client.RemoveObject(ctx, Instance.Bucket, objectName, minio.RemoveObjectOptions{})
+}
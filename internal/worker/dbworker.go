@@ -3,17 +3,20 @@ package worker
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"log"
 
 	"corechain-communication/internal/chat"
 	"corechain-communication/internal/config"
 	"corechain-communication/internal/db"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/segmentio/kafka-go"
 )
 
-func StartDBWorker(cfg *config.Config, q *db.Queries) {
+func StartDBWorker(cfg *config.Config, pool *pgxpool.Pool, q *db.Queries, hub *chat.Hub) {
 	reader := kafka.NewReader(kafka.ReaderConfig{
 		Brokers:  []string{cfg.KafkaBroker},
 		Topic:    cfg.KafkaTopicPersistence,
@@ -38,53 +41,321 @@ func StartDBWorker(cfg *config.Config, q *db.Queries) {
 			continue
 		}
 		log.Printf("Received message type: %v", msg.Type)
-		if msg.Type == "mark_as_read" {
-			err := q.MarkMessageAsRead(context.Background(), db.MarkMessageAsReadParams{
-				ConversationID:    msg.ConversationID,
-				UserID:            msg.SenderID,
-				LastReadMessageID: pgtype.Int8{Int64: msg.LastReadMessageID, Valid: msg.LastReadMessageID > 0},
-			})
-			if err != nil {
-				log.Printf("DB MarkRead Error (Conv %d, User %s): %v", msg.ConversationID, msg.SenderID, err)
-			} else {
-				log.Printf("Successfully MarkRead: User=%s | Conv=%d | MsgID=%d",
-					msg.SenderID, msg.ConversationID, msg.LastReadMessageID)
-			}
-			continue
+
+		switch msg.Type {
+		case "mark_as_read":
+			markAsRead(q, hub, msg)
+		case "mark_as_delivered":
+			markAsDelivered(q, hub, msg)
+		case "edit_message":
+			editMessage(q, hub, msg)
+		case "add_reaction", "remove_reaction":
+			reactToMessage(q, hub, msg)
+		case "pin_message", "unpin_message":
+			pinMessage(pool, q, hub, msg)
+		default:
+			persistMessage(q, hub, msg)
 		}
+	}
+}
+
+func markAsRead(q *db.Queries, hub *chat.Hub, msg chat.Message) {
+	ctx := context.Background()
+	receipt, err := chat.MarkRead(ctx, q, msg.SenderID, msg.ConversationID, msg.LastReadMessageID)
+	switch {
+	case errors.Is(err, chat.ErrNotMember):
+		hub.SendError(ctx, msg.SenderID, chat.ErrCodeNotMember, err.Error(), &msg)
+	case errors.Is(err, chat.ErrReadBackwards), errors.Is(err, chat.ErrReadBeyondLatest):
+		hub.SendError(ctx, msg.SenderID, chat.ErrCodeInvalidFrame, err.Error(), &msg)
+	case err != nil:
+		log.Printf("DB MarkRead Error (Conv %d, User %s): %v", msg.ConversationID, msg.SenderID, err)
+		hub.SendError(ctx, msg.SenderID, chat.ErrCodeInternal, "read position could not be saved, please retry", &msg)
+	case receipt != nil:
+		log.Printf("Successfully MarkRead: User=%s | Conv=%d | MsgID=%d",
+			msg.SenderID, msg.ConversationID, msg.LastReadMessageID)
+		hub.BroadcastReadReceipt(ctx, *receipt)
+	}
+}
+
+func markAsDelivered(q *db.Queries, hub *chat.Hub, msg chat.Message) {
+	ctx := context.Background()
+	receipt, err := chat.MarkDelivered(ctx, q, msg.SenderID, msg.ConversationID, msg.LastDeliveredMessageID)
+	switch {
+	case errors.Is(err, chat.ErrNotMember):
+		hub.SendError(ctx, msg.SenderID, chat.ErrCodeNotMember, err.Error(), &msg)
+	case errors.Is(err, chat.ErrDeliveredBeyondLatest):
+		hub.SendError(ctx, msg.SenderID, chat.ErrCodeInvalidFrame, err.Error(), &msg)
+	case err != nil:
+		log.Printf("DB MarkDelivered Error (Conv %d, User %s): %v", msg.ConversationID, msg.SenderID, err)
+	case receipt != nil:
+		hub.BroadcastDelivered(ctx, *receipt)
+	}
+}
 
-		params := db.CreateMessageParams{
-			ConversationID: msg.ConversationID,
-			SenderID:       msg.SenderID,
-			Content:        pgtype.Text{String: msg.Content, Valid: msg.Content != ""},
-			Type:           pgtype.Text{String: msg.Type, Valid: true},
+func editMessage(q *db.Queries, hub *chat.Hub, msg chat.Message) {
+	ctx := context.Background()
+	edited, err := q.EditMessage(ctx, db.EditMessageParams{
+		ID:             msg.ID,
+		ConversationID: msg.ConversationID,
+		SenderID:       msg.SenderID,
+		Content:        pgtype.Text{String: msg.Content, Valid: true},
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		hub.SendError(ctx, msg.SenderID, chat.ErrCodeNotFound, "message not found or not yours", &msg)
+		return
+	}
+	if err != nil {
+		log.Printf("DB Edit Error (Msg %d, User %s): %v", msg.ID, msg.SenderID, err)
+		hub.SendError(ctx, msg.SenderID, chat.ErrCodeInternal, "message could not be edited, please retry", &msg)
+		return
+	}
+
+	log.Printf("Successfully Edited: ID=%d | From=%s | Conv=%d", edited.ID, msg.SenderID, msg.ConversationID)
+	hub.BroadcastEdit(ctx, edited)
+}
 
-			FileName: pgtype.Text{String: msg.FileName, Valid: msg.FileName != ""},
-			FilePath: pgtype.Text{String: msg.FilePath, Valid: msg.FilePath != ""},
-			FileType: pgtype.Text{String: msg.FileType, Valid: msg.FileType != ""},
-			FileSize: pgtype.Int8{Int64: msg.FileSize, Valid: msg.FileSize > 0},
+func reactToMessage(q *db.Queries, hub *chat.Hub, msg chat.Message) {
+	ctx := context.Background()
+	target, err := q.GetMessageByID(ctx, db.GetMessageByIDParams{
+		ID:             msg.ID,
+		ConversationID: msg.ConversationID,
+	})
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && target.IsDeleted.Bool) {
+		hub.SendError(ctx, msg.SenderID, chat.ErrCodeNotFound, "message not found", &msg)
+		return
+	}
+	if err != nil {
+		log.Printf("DB Reaction Lookup Error (Msg %d, Conv %d): %v", msg.ID, msg.ConversationID, err)
+		hub.SendError(ctx, msg.SenderID, chat.ErrCodeInternal, "reaction could not be saved, please retry", &msg)
+		return
+	}
 
-			ReplyToID:   pgtype.Int8{Valid: false},
-			ClientMsgID: pgtype.Text{String: msg.ClientMsgID, Valid: msg.ClientMsgID != ""},
+	var changed int64
+	if msg.Type == "add_reaction" {
+		changed, err = q.AddReaction(ctx, db.AddReactionParams{
+			MessageID: msg.ID,
+			UserID:    msg.SenderID,
+			Emoji:     msg.Emoji,
+		})
+	} else {
+		changed, err = q.RemoveReaction(ctx, db.RemoveReactionParams{
+			MessageID: msg.ID,
+			UserID:    msg.SenderID,
+			Emoji:     msg.Emoji,
+		})
+	}
+	if err != nil {
+		log.Printf("DB Reaction Error (Msg %d, User %s): %v", msg.ID, msg.SenderID, err)
+		hub.SendError(ctx, msg.SenderID, chat.ErrCodeInternal, "reaction could not be saved, please retry", &msg)
+		return
+	}
+
+	// Repeating an add or a remove is a no-op and is not broadcast again.
+	if changed > 0 {
+		hub.BroadcastReaction(ctx, msg)
+	}
+}
+
+func pinMessage(pool *pgxpool.Pool, q *db.Queries, hub *chat.Hub, msg chat.Message) {
+	ctx := context.Background()
+
+	var (
+		system  *db.Message
+		changed bool
+		err     error
+	)
+	if msg.Type == "pin_message" {
+		system, err = chat.PinMessage(ctx, pool, q, msg.SenderID, msg.SenderName, msg.ConversationID, msg.ID)
+		changed = system != nil
+	} else {
+		changed, err = chat.UnpinMessage(ctx, q, msg.SenderID, msg.ConversationID, msg.ID)
+	}
+
+	switch {
+	case errors.Is(err, chat.ErrNotMember):
+		hub.SendError(ctx, msg.SenderID, chat.ErrCodeNotMember, err.Error(), &msg)
+	case errors.Is(err, chat.ErrPinNotAllowed):
+		hub.SendError(ctx, msg.SenderID, chat.ErrCodeForbidden, err.Error(), &msg)
+	case errors.Is(err, chat.ErrMessageNotFound):
+		hub.SendError(ctx, msg.SenderID, chat.ErrCodeNotFound, err.Error(), &msg)
+	case errors.Is(err, chat.ErrPinLimitReached):
+		hub.SendError(ctx, msg.SenderID, chat.ErrCodeLimitReached, err.Error(), &msg)
+	case err != nil:
+		log.Printf("DB Pin Error (Msg %d, User %s): %v", msg.ID, msg.SenderID, err)
+		hub.SendError(ctx, msg.SenderID, chat.ErrCodeInternal, "pin could not be saved, please retry", &msg)
+	case changed:
+		eventType := "message_pinned"
+		if msg.Type == "unpin_message" {
+			eventType = "message_unpinned"
 		}
+		hub.BroadcastPin(ctx, eventType, msg.SenderID, msg.ConversationID, msg.ID, system)
+	}
+}
 
-		insertedMsg, err := q.CreateMessage(context.Background(), params)
+func persistMessage(q *db.Queries, hub *chat.Hub, msg chat.Message) {
+	// A forwarded copy points at someone else's upload by design; the
+	// forward handler already checked the caller could see the original.
+	if msg.FilePath != "" && msg.ForwardedFrom == 0 {
+		owned, err := ownsUpload(context.Background(), q, msg)
 		if err != nil {
-			log.Printf("DB Save Error (Conv %d, Sender %s): %v", msg.ConversationID, msg.SenderID, err)
-			continue
+			log.Printf("DB Upload Lookup Error (%s, Sender %s): %v", msg.FilePath, msg.SenderID, err)
+			hub.Nack(context.Background(), msg, "message could not be saved, please retry")
+			return
+		}
+		if !owned {
+			hub.Nack(context.Background(), msg, "file_path is not one of your uploads")
+			return
 		}
+	}
 
-		// Update conversation last message metadata
-		err = q.UpdateConversationLastMessage(context.Background(), db.UpdateConversationLastMessageParams{
-			ID:            msg.ConversationID,
-			LastMessageID: pgtype.Int8{Int64: insertedMsg.ID, Valid: true},
-			LastMessageAt: insertedMsg.CreatedAt,
-		})
-		if err != nil {
-			log.Printf("DB Update Conv Error (Conv %d): %v", msg.ConversationID, err)
+	// The quote is always rebuilt from the stored parent, never trusted
+	// from the client.
+	quote, err := replyQuote(context.Background(), q, msg)
+	if errors.Is(err, errUnknownParent) {
+		hub.Nack(context.Background(), msg, err.Error())
+		return
+	}
+	if err != nil {
+		log.Printf("DB Reply Lookup Error (Msg %d, Conv %d): %v", msg.ReplyToID, msg.ConversationID, err)
+		hub.Nack(context.Background(), msg, "message could not be saved, please retry")
+		return
+	}
+	msg.ReplyTo = quote
+
+	var mentions []byte
+	if len(msg.Mentions) > 0 {
+		if mentions, err = json.Marshal(msg.Mentions); err != nil {
+			log.Printf("Failed to encode mentions (Conv %d, Sender %s): %v", msg.ConversationID, msg.SenderID, err)
+			hub.Nack(context.Background(), msg, "mentions could not be encoded")
+			return
 		}
+	}
+
+	var systemPayload []byte
+	if msg.Type == chat.MessageTypeSystem && msg.System != nil {
+		var err error
+		if systemPayload, err = json.Marshal(msg.System); err != nil {
+			log.Printf("Failed to encode system payload (Conv %d): %v", msg.ConversationID, err)
+			return
+		}
+	}
+
+	params := db.CreateMessageParams{
+		ConversationID: msg.ConversationID,
+		SenderID:       msg.SenderID,
+		Content:        pgtype.Text{String: msg.Content, Valid: msg.Content != ""},
+		Type:           pgtype.Text{String: msg.Type, Valid: true},
+
+		FileName: pgtype.Text{String: msg.FileName, Valid: msg.FileName != ""},
+		FilePath: pgtype.Text{String: msg.FilePath, Valid: msg.FilePath != ""},
+		FileType: pgtype.Text{String: msg.FileType, Valid: msg.FileType != ""},
+		FileSize: pgtype.Int8{Int64: msg.FileSize, Valid: msg.FileSize > 0},
+
+		ReplyToID:   pgtype.Int8{Int64: msg.ReplyToID, Valid: msg.ReplyToID > 0},
+		ClientMsgID: pgtype.Text{String: msg.ClientMsgID, Valid: msg.ClientMsgID != ""},
+		Mentions:    mentions,
+
+		ForwardedFrom: pgtype.Int8{Int64: msg.ForwardedFrom, Valid: msg.ForwardedFrom > 0},
+		SystemPayload: systemPayload,
+	}
+
+	insertedMsg, created, err := saveMessage(context.Background(), q, params)
+	if err != nil {
+		log.Printf("DB Save Error (Conv %d, Sender %s): %v", msg.ConversationID, msg.SenderID, err)
+		hub.Nack(context.Background(), msg, "message could not be saved, please retry")
+		return
+	}
+
+	if !created && insertedMsg.SenderID != msg.SenderID {
+		log.Printf("ClientMsgID %s in Conv %d already used by another sender", msg.ClientMsgID, msg.ConversationID)
+		hub.Nack(context.Background(), msg, "client_msg_id already in use")
+		return
+	}
+
+	msg.ID = insertedMsg.ID
+	msg.CreatedAt = insertedMsg.CreatedAt.Time
+
+	if !created {
+		// A retry or a Kafka redelivery: the original was already
+		// persisted and broadcast, so only repeat the ack.
+		log.Printf("Duplicate message ignored: ID=%d | ClientMsgID=%s | Conv=%d",
+			insertedMsg.ID, msg.ClientMsgID, msg.ConversationID)
+		hub.Ack(context.Background(), msg)
+		return
+	}
+
+	// Update conversation last message metadata
+	err = q.UpdateConversationLastMessage(context.Background(), db.UpdateConversationLastMessageParams{
+		ID:            msg.ConversationID,
+		LastMessageID: pgtype.Int8{Int64: insertedMsg.ID, Valid: true},
+		LastMessageAt: insertedMsg.CreatedAt,
+	})
+	if err != nil {
+		log.Printf("DB Update Conv Error (Conv %d): %v", msg.ConversationID, err)
+	}
+	if msg.Type != chat.MessageTypeSystem {
+		if err := q.UnarchiveConversation(context.Background(), msg.ConversationID); err != nil {
+			log.Printf("DB Unarchive Error (Conv %d): %v", msg.ConversationID, err)
+		}
+	}
+
+	log.Printf("Successfully Persisted: ID=%d | Type=%s | From=%s | Conv=%d",
+		insertedMsg.ID, msg.Type, msg.SenderID, msg.ConversationID)
+
+	hub.Ack(context.Background(), msg)
+	hub.BroadcastMessage(context.Background(), msg)
+}
 
-		log.Printf("Successfully Persisted: ID=%d | Type=%s | From=%s | Conv=%d",
-			insertedMsg.ID, msg.Type, msg.SenderID, msg.ConversationID)
+var errUnknownParent = errors.New("reply_to_id is not a message of this conversation")
+
+// replyQuote loads the parent a reply quotes, which must belong to the same
+// conversation. It returns nil when the message is not a reply.
+func replyQuote(ctx context.Context, q *db.Queries, msg chat.Message) (*chat.QuotedMessage, error) {
+	if msg.ReplyToID <= 0 {
+		return nil, nil
+	}
+	parent, err := q.GetMessageByID(ctx, db.GetMessageByIDParams{
+		ID:             msg.ReplyToID,
+		ConversationID: msg.ConversationID,
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, errUnknownParent
 	}
+	if err != nil {
+		return nil, err
+	}
+	return chat.NewQuotedMessage(parent), nil
+}
+
+// ownsUpload reports whether the sender uploaded the file the message points
+// at. Attaching anyone else's object would expose it and, once the message
+// is recalled, get it deleted.
+func ownsUpload(ctx context.Context, q *db.Queries, msg chat.Message) (bool, error) {
+	owner, err := q.GetUploadOwner(ctx, msg.FilePath)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return owner == msg.SenderID, nil
+}
+
+// saveMessage inserts the message unless one with the same client_msg_id
+// already exists in the conversation, in which case the existing row is
+// returned and created is false.
+func saveMessage(ctx context.Context, q *db.Queries, params db.CreateMessageParams) (msg db.Message, created bool, err error) {
+	msg, err = q.CreateMessage(ctx, params)
+	if err == nil {
+		return msg, true, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) || !params.ClientMsgID.Valid {
+		return msg, false, err
+	}
+
+	msg, err = q.GetMessageByClientMsgID(ctx, db.GetMessageByClientMsgIDParams{
+		ConversationID: params.ConversationID,
+		ClientMsgID:    params.ClientMsgID,
+	})
+	return msg, false, err
 }
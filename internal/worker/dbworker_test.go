@@ -0,0 +1,165 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+
+	"corechain-communication/internal/chat"
+	"corechain-communication/internal/config"
+	"corechain-communication/internal/db"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// newTestQueries connects to the Postgres named by TEST_DATABASE_URL and
+// migrates it. Tests using it are skipped when the variable is unset.
+func newTestQueries(t *testing.T) *db.Queries {
+	t.Helper()
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	if _, err := config.LoadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	db.RunMigration("file://../db/migration", url)
+
+	pool, err := pgxpool.New(context.Background(), url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Close)
+	return db.New(pool)
+}
+
+func newTestConversation(t *testing.T, q *db.Queries) int64 {
+	t.Helper()
+	conv, err := q.CreateConversation(context.Background(), db.CreateConversationParams{
+		Name: pgtype.Text{String: t.Name(), Valid: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conv.ID
+}
+
+func TestSaveMessageIsIdempotent(t *testing.T) {
+	q := newTestQueries(t)
+	ctx := context.Background()
+
+	params := func(convID int64, clientMsgID, content string) db.CreateMessageParams {
+		return db.CreateMessageParams{
+			ConversationID: convID,
+			SenderID:       "alice",
+			Content:        pgtype.Text{String: content, Valid: true},
+			Type:           pgtype.Text{String: "text", Valid: true},
+			ClientMsgID:    pgtype.Text{String: clientMsgID, Valid: true},
+		}
+	}
+
+	convID := newTestConversation(t, q)
+	clientMsgID := uuid.NewString()
+	first, created, err := saveMessage(ctx, q, params(convID, clientMsgID, "hello"))
+	if err != nil || !created {
+		t.Fatalf("first save: created=%v, err=%v", created, err)
+	}
+
+	retry, created, err := saveMessage(ctx, q, params(convID, clientMsgID, "hello again"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created || retry.ID != first.ID || retry.Content.String != "hello" {
+		t.Errorf("retry: created=%v, got message %d %q, want the original %d", created, retry.ID, retry.Content.String, first.ID)
+	}
+
+	// The same client_msg_id is only a duplicate within one conversation.
+	other, created, err := saveMessage(ctx, q, params(newTestConversation(t, q), clientMsgID, "hello"))
+	if err != nil || !created || other.ID == first.ID {
+		t.Errorf("other conversation: created=%v, id=%d, err=%v", created, other.ID, err)
+	}
+}
+
+func TestOwnsUpload(t *testing.T) {
+	q := newTestQueries(t)
+	ctx := context.Background()
+
+	path := uuid.NewString() + ".png"
+	if err := q.RecordUpload(ctx, db.RecordUploadParams{FilePath: path, UserID: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		sender string
+		path   string
+		want   bool
+	}{
+		{"uploader", "alice", path, true},
+		{"someone else", "mallory", path, false},
+		{"unknown file", "alice", uuid.NewString() + ".png", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ownsUpload(ctx, q, chat.Message{SenderID: tt.sender, FilePath: tt.path})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("ownsUpload() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReplyQuoteRequiresParentInConversation(t *testing.T) {
+	q := newTestQueries(t)
+	ctx := context.Background()
+	convID, otherConvID := newTestConversation(t, q), newTestConversation(t, q)
+
+	newMessage := func(convID int64) db.Message {
+		msg, _, err := saveMessage(ctx, q, db.CreateMessageParams{
+			ConversationID: convID,
+			SenderID:       "alice",
+			Content:        pgtype.Text{String: "parent", Valid: true},
+			Type:           pgtype.Text{String: "text", Valid: true},
+			ClientMsgID:    pgtype.Text{String: uuid.NewString(), Valid: true},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return msg
+	}
+	parent := newMessage(convID)
+	foreign := newMessage(otherConvID)
+
+	tests := []struct {
+		name      string
+		replyToID int64
+		wantQuote int64
+		wantErr   error
+	}{
+		{"not a reply", 0, 0, nil},
+		{"parent in the conversation", parent.ID, parent.ID, nil},
+		{"parent in another conversation", foreign.ID, 0, errUnknownParent},
+		{"missing parent", foreign.ID + 1000000, 0, errUnknownParent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			quote, err := replyQuote(ctx, q, chat.Message{ConversationID: convID, ReplyToID: tt.replyToID})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("replyQuote() error = %v, want %v", err, tt.wantErr)
+			}
+			var got int64
+			if quote != nil {
+				got = quote.ID
+			}
+			if got != tt.wantQuote {
+				t.Errorf("quoted message %d, want %d", got, tt.wantQuote)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package worker
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"corechain-communication/internal/db"
+	"corechain-communication/internal/storage"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const (
+	fileCleanupInterval  = time.Minute
+	fileCleanupBatchSize = 100
+)
+
+// StartFileCleanupWorker removes the MinIO objects of recalled file messages
+// once their scheduled time has come.
+func StartFileCleanupWorker(q *db.Queries) {
+	ticker := time.NewTicker(fileCleanupInterval)
+	defer ticker.Stop()
+
+	log.Println("File cleanup worker started")
+
+	for range ticker.C {
+		cleanupFiles(q)
+	}
+}
+
+func cleanupFiles(q *db.Queries) {
+	ctx := context.Background()
+
+	jobs, err := q.ListDueFileCleanups(ctx, fileCleanupBatchSize)
+	if err != nil {
+		log.Printf("Failed to list file cleanups: %v", err)
+		return
+	}
+
+	for _, job := range jobs {
+		// Another live message may still point at the same object.
+		n, err := q.CountMessagesByFilePath(ctx, pgtype.Text{String: job.FilePath, Valid: true})
+		if err != nil {
+			log.Printf("Failed to check references of %s: %v", job.FilePath, err)
+			continue
+		}
+		if n == 0 {
+			if err := storage.RemoveObject(ctx, job.FilePath); err != nil {
+				log.Printf("Failed to remove object %s: %v", job.FilePath, err)
+				continue
+			}
+			log.Printf("Removed recalled file %s", job.FilePath)
+		}
+
+		if err := q.DeleteFileCleanup(ctx, job.ID); err != nil {
+			log.Printf("Failed to delete file cleanup %d: %v", job.ID, err)
+		}
+	}
+}